@@ -0,0 +1,5 @@
+// +k8s:deepcopy-gen=package
+// +groupName=spot-tolerator.stein.solutions
+
+// Package v1alpha1 contains the SpotPolicy api of the aks-spot-instance-tolerator.
+package v1alpha1
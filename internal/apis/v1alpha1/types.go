@@ -0,0 +1,62 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Action is what happens to pods matched by a rule. The values correspond to the modes
+// of the webhook.
+type Action string
+
+const (
+	ActionSkip     Action = "skip"
+	ActionTolerate Action = "tolerate"
+	ActionPrefer   Action = "prefer"
+	ActionRequire  Action = "require"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SpotPolicy decides how pods are placed on spot nodes. Policies are evaluated in order of
+// their priority (lowest first) and name, the rules of a policy in the order they are
+// declared. The first matching rule wins.
+type SpotPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SpotPolicySpec `json:"spec"`
+}
+
+type SpotPolicySpec struct {
+	Priority int32            `json:"priority,omitempty"`
+	Rules    []SpotPolicyRule `json:"rules"`
+}
+
+// SpotPolicyRule matches pods by all of its selectors. Selectors that are not set match
+// every pod.
+type SpotPolicyRule struct {
+	Name              string                `json:"name,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// OwnerKinds matches the kind of the top-level owner of the pod, Pod for pods without owner.
+	OwnerKinds []string `json:"ownerKinds,omitempty"`
+	// Match contains CEL expressions that all need to evaluate to true. The pod is available
+	// as object, its namespace as namespaceObject and the user creating it as request.userInfo.
+	Match  []string `json:"match,omitempty"`
+	Action Action   `json:"action"`
+	// Tolerations are injected in addition to the configured tolerations.
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// NodeAffinity is merged into the node affinity of the pod. The required node selector
+	// may contain at most one term, its expressions are added to every term of the pod.
+	NodeAffinity *corev1.NodeAffinity `json:"nodeAffinity,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+type SpotPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SpotPolicy `json:"items"`
+}
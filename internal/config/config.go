@@ -1,38 +1,101 @@
 package config
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
+	"strconv"
 	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	SpotNodeLabelKey   = "kubernetes.azure.com/scalesetpriority"
+	SpotNodeLabelValue = "spot"
+	AgentPoolLabelKey  = "kubernetes.azure.com/agentpool"
 )
 
+var SpotToleration = corev1.Toleration{
+	Key:      SpotNodeLabelKey,
+	Operator: corev1.TolerationOpEqual,
+	Value:    SpotNodeLabelValue,
+	Effect:   corev1.TaintEffectNoSchedule,
+}
+
 type Config struct {
-	Namespace            string
-	SvcName              string
-	SecretName           string
-	KubeConfig           string
-	CertDirPath          string
-	WebhookName          string
-	WebhookPort          string
-	HealthPort           string
-	LogLevel             slog.Level
-	TlsValidForSeconds   int
-	TlsRenewEarlySeconds int
+	Namespace                string
+	SvcName                  string
+	SecretName               string
+	KubeConfig               string
+	CertDirPath              string
+	WebhookName              string
+	WebhookPort              string
+	HealthPort               string
+	LogLevel                 slog.Level
+	TlsValidForSeconds       int
+	TlsRenewEarlySeconds     int
+	Tolerations              []corev1.Toleration
+	DefaultMode              policy.Mode
+	KindDefaults             map[string]policy.Mode
+	RiskModes                map[policy.Risk]policy.Mode
+	MinReplicasForSpot       int
+	MinOnDemandReplicas      int
+	TopologySpread           []corev1.TopologySpreadConstraint
+	SpotAffinityWeight       int32
+	AuditMode                bool
+	ValidationWarnOnly       bool
+	NodePoolDiscovery        bool
+	NodePoolTaintKeys        []string
+	NodePoolIgnoredTaintKeys []string
+	NoSpotCapacityMode       policy.Mode
+	FallbackAfterSeconds     int
+	RebalanceSeconds         int
+	RebalanceEvictions       int
+	RebalanceDisruptions     int
+	CacheResyncSeconds       int
+	NodeAgent                bool
+	NodeName                 string
+	ScheduledEventsURL       string
 }
 
 func NewConfig() *Config {
 	return &Config{
-		Namespace:            getNamespace(),
-		SvcName:              getServiceName(),
-		SecretName:           getSecretName(),
-		KubeConfig:           getKubeConfig(),
-		CertDirPath:          getCertDirPath(),
-		WebhookName:          getWebhookName(),
-		WebhookPort:          getWebhookPort(),
-		HealthPort:           getHealthPort(),
-		TlsValidForSeconds:   int(time.Hour.Seconds() * 24 * 10),
-		TlsRenewEarlySeconds: int(time.Hour.Seconds() * 24 * 5),
-		LogLevel:             getLogLevel(),
+		Namespace:                getNamespace(),
+		SvcName:                  getServiceName(),
+		SecretName:               getSecretName(),
+		KubeConfig:               getKubeConfig(),
+		CertDirPath:              getCertDirPath(),
+		WebhookName:              getWebhookName(),
+		WebhookPort:              getWebhookPort(),
+		HealthPort:               getHealthPort(),
+		TlsValidForSeconds:       int(time.Hour.Seconds() * 24 * 10),
+		TlsRenewEarlySeconds:     int(time.Hour.Seconds() * 24 * 5),
+		LogLevel:                 getLogLevel(),
+		Tolerations:              getTolerations(),
+		DefaultMode:              getDefaultMode(),
+		KindDefaults:             getKindDefaults(),
+		RiskModes:                getRiskModes(),
+		MinReplicasForSpot:       getMinReplicasForSpot(),
+		MinOnDemandReplicas:      getMinOnDemandReplicas(),
+		TopologySpread:           getTopologySpread(),
+		SpotAffinityWeight:       getSpotAffinityWeight(),
+		AuditMode:                getEnvBool("AKS_SPOT_INSTANCE_TOLERATOR_AUDIT_MODE"),
+		ValidationWarnOnly:       getEnvBool("AKS_SPOT_INSTANCE_TOLERATOR_VALIDATION_WARN_ONLY"),
+		NodePoolDiscovery:        getEnvBool("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_DISCOVERY"),
+		NodePoolTaintKeys:        getStringList("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_TAINT_KEYS"),
+		NodePoolIgnoredTaintKeys: getStringList("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_IGNORED_TAINT_KEYS"),
+		NoSpotCapacityMode:       getNoSpotCapacityMode(),
+		FallbackAfterSeconds:     getFallbackAfterSeconds(),
+		RebalanceSeconds:         getRebalanceSeconds(),
+		RebalanceEvictions:       getPositiveInt("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_EVICTIONS", 1),
+		RebalanceDisruptions:     getPositiveInt("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_DISRUPTIONS", 5),
+		CacheResyncSeconds:       int(time.Minute.Seconds() * 10),
+		NodeAgent:                getEnvBool("AKS_SPOT_INSTANCE_TOLERATOR_NODE_AGENT"),
+		NodeName:                 os.Getenv("NODE_NAME"),
+		ScheduledEventsURL:       getScheduledEventsURL(),
 	}
 }
 
@@ -110,3 +173,274 @@ func getNamespace() string {
 	}
 	return "default"
 }
+
+func getDefaultMode() policy.Mode {
+	if defaultMode, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_DEFAULT_MODE"); exists {
+		mode, err := policy.ParseMode(defaultMode)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid default mode. Using %s. %v", policy.ModeTolerate, err))
+			return policy.ModeTolerate
+		}
+		return mode
+	}
+	return policy.ModeTolerate
+}
+
+// getNoSpotCapacityMode returns the strongest mode while no spot nodes are ready, either skip
+// or tolerate. An empty mode disables the capacity check.
+func getNoSpotCapacityMode() policy.Mode {
+	value, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_NO_SPOT_CAPACITY_MODE")
+	if !exists || value == "" {
+		return ""
+	}
+
+	mode, err := policy.ParseMode(value)
+	if err == nil && mode != policy.ModeSkip && mode != policy.ModeTolerate {
+		err = fmt.Errorf("mode %s is not supported, use skip or tolerate", mode)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Invalid mode without spot capacity. Disabling the capacity check. %v", err))
+		return ""
+	}
+	return mode
+}
+
+func getKindDefaults() map[string]policy.Mode {
+	kindDefaults := map[string]policy.Mode{}
+	value, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_KIND_DEFAULTS")
+	if !exists {
+		return kindDefaults
+	}
+
+	modes := map[string]string{}
+	if err := yaml.UnmarshalStrict([]byte(value), &modes); err != nil {
+		slog.Error(fmt.Sprintf("Could not parse kind defaults. Ignoring them. %v", err))
+		return kindDefaults
+	}
+	for kind, value := range modes {
+		mode, err := policy.ParseMode(value)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid default for kind %s. Ignoring kind defaults. %v", kind, err))
+			return map[string]policy.Mode{}
+		}
+		kindDefaults[kind] = mode
+	}
+	return kindDefaults
+}
+
+func getRiskModes() map[policy.Risk]policy.Mode {
+	riskModes := map[policy.Risk]policy.Mode{}
+	value, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_RISK_MODES")
+	if !exists {
+		return riskModes
+	}
+
+	modes := map[policy.Risk]string{}
+	if err := yaml.UnmarshalStrict([]byte(value), &modes); err != nil {
+		slog.Error(fmt.Sprintf("Could not parse risk modes. Ignoring them. %v", err))
+		return riskModes
+	}
+	for risk, value := range modes {
+		if !risk.IsValid() {
+			slog.Error(fmt.Sprintf("Unknown risk %s. Ignoring risk modes.", risk))
+			return map[policy.Risk]policy.Mode{}
+		}
+		mode, err := policy.ParseMode(value)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid mode for risk %s. Ignoring risk modes. %v", risk, err))
+			return map[policy.Risk]policy.Mode{}
+		}
+		riskModes[risk] = mode
+	}
+	return riskModes
+}
+
+func getMinReplicasForSpot() int {
+	return getNonNegativeInt("AKS_SPOT_INSTANCE_TOLERATOR_MIN_REPLICAS_FOR_SPOT")
+}
+
+func getMinOnDemandReplicas() int {
+	return getNonNegativeInt("AKS_SPOT_INSTANCE_TOLERATOR_MIN_ON_DEMAND_REPLICAS")
+}
+
+// getFallbackAfterSeconds returns how long pods requiring spot nodes may stay unschedulable
+// before their workload falls back to on-demand nodes. 0 disables the fallback.
+func getFallbackAfterSeconds() int {
+	return getNonNegativeInt("AKS_SPOT_INSTANCE_TOLERATOR_FALLBACK_AFTER_SECONDS")
+}
+
+// getRebalanceSeconds returns the interval in which pods preferring spot nodes are moved from
+// on-demand to spot nodes. 0 disables the rebalancing.
+func getRebalanceSeconds() int {
+	return getNonNegativeInt("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_SECONDS")
+}
+
+func getPositiveInt(name string, fallback int) int {
+	if value, exists := os.LookupEnv(name); exists {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			slog.Error(fmt.Sprintf("Invalid value %q for %s. Using %d.", value, name, fallback))
+			return fallback
+		}
+		return number
+	}
+	return fallback
+}
+
+func getNonNegativeInt(name string) int {
+	if value, exists := os.LookupEnv(name); exists {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 0 {
+			slog.Error(fmt.Sprintf("Invalid value %q for %s. Using 0.", value, name))
+			return 0
+		}
+		return number
+	}
+	return 0
+}
+
+func getSpotAffinityWeight() int32 {
+	if weight, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_SPOT_AFFINITY_WEIGHT"); exists {
+		value, err := strconv.ParseInt(weight, 10, 32)
+		if err != nil || value < 1 || value > 100 {
+			slog.Error(fmt.Sprintf("Invalid spot affinity weight %q. It has to be between 1 and 100. Using 100.", weight))
+			return 100
+		}
+		return int32(value)
+	}
+	return 100
+}
+
+func getEnvBool(name string) bool {
+	if value, exists := os.LookupEnv(name); exists {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Invalid value %q for %s. Using false.", value, name))
+			return false
+		}
+		return enabled
+	}
+	return false
+}
+
+// getStringList reads a json or yaml list of strings. An invalid list is ignored.
+func getStringList(name string) []string {
+	value, exists := os.LookupEnv(name)
+	if !exists {
+		return nil
+	}
+
+	list := []string{}
+	if err := yaml.UnmarshalStrict([]byte(value), &list); err != nil {
+		slog.Error(fmt.Sprintf("Could not parse %s. Ignoring it. %v", name, err))
+		return nil
+	}
+	return list
+}
+
+func getScheduledEventsURL() string {
+	if url, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_SCHEDULED_EVENTS_URL"); exists {
+		return url
+	}
+	return "http://169.254.169.254/metadata/scheduledevents?api-version=2020-07-01"
+}
+
+func getTopologySpread() []corev1.TopologySpreadConstraint {
+	value, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_TOPOLOGY_SPREAD")
+	if !exists {
+		return nil
+	}
+
+	constraints, err := parseTopologySpread([]byte(value))
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not parse topology spread constraints. Ignoring them. %v", err))
+		return nil
+	}
+	return constraints
+}
+
+// parseTopologySpread accepts a json or yaml list of topology spread constraints. The label
+// selector is filled per pod and must not be set.
+func parseTopologySpread(data []byte) ([]corev1.TopologySpreadConstraint, error) {
+	constraints := []corev1.TopologySpreadConstraint{}
+	if err := yaml.UnmarshalStrict(data, &constraints); err != nil {
+		return nil, err
+	}
+
+	for i, constraint := range constraints {
+		if constraint.TopologyKey == "" {
+			return nil, fmt.Errorf("topology spread constraint %d has no topologyKey", i)
+		}
+		if constraint.LabelSelector != nil {
+			return nil, fmt.Errorf("topology spread constraint %d must not set a labelSelector", i)
+		}
+		if constraint.MaxSkew == 0 {
+			constraints[i].MaxSkew = 1
+		}
+		switch constraint.WhenUnsatisfiable {
+		case "":
+			constraints[i].WhenUnsatisfiable = corev1.ScheduleAnyway
+		case corev1.ScheduleAnyway, corev1.DoNotSchedule:
+		default:
+			return nil, fmt.Errorf("topology spread constraint %d has invalid whenUnsatisfiable %q", i, constraint.WhenUnsatisfiable)
+		}
+	}
+
+	return constraints, nil
+}
+
+func getTolerations() []corev1.Toleration {
+	defaultTolerations := []corev1.Toleration{SpotToleration}
+
+	var data []byte
+	if tolerations, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_TOLERATIONS"); exists {
+		data = []byte(tolerations)
+	} else if path, exists := os.LookupEnv("AKS_SPOT_INSTANCE_TOLERATOR_TOLERATIONS_FILE"); exists {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Could not read tolerations file %s. Using default. %v", path, err))
+			return defaultTolerations
+		}
+		data = fileData
+	} else {
+		return defaultTolerations
+	}
+
+	tolerations, err := parseTolerations(data)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not parse tolerations. Using default. %v", err))
+		return defaultTolerations
+	}
+	return tolerations
+}
+
+// parseTolerations accepts a json or yaml list of tolerations.
+func parseTolerations(data []byte) ([]corev1.Toleration, error) {
+	tolerations := []corev1.Toleration{}
+	if err := yaml.UnmarshalStrict(data, &tolerations); err != nil {
+		return nil, err
+	}
+
+	for i, toleration := range tolerations {
+		switch toleration.Operator {
+		case "":
+			tolerations[i].Operator = corev1.TolerationOpEqual
+		case corev1.TolerationOpEqual, corev1.TolerationOpExists:
+		default:
+			return nil, fmt.Errorf("toleration %d has invalid operator %q", i, toleration.Operator)
+		}
+		if tolerations[i].Operator == corev1.TolerationOpExists && toleration.Value != "" {
+			return nil, fmt.Errorf("toleration %d must not have a value when operator is Exists", i)
+		}
+		switch toleration.Effect {
+		case "", corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			return nil, fmt.Errorf("toleration %d has invalid effect %q", i, toleration.Effect)
+		}
+		if toleration.TolerationSeconds != nil && toleration.Effect != corev1.TaintEffectNoExecute {
+			return nil, fmt.Errorf("toleration %d may only set tolerationSeconds with effect NoExecute", i)
+		}
+	}
+
+	return tolerations, nil
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestGetTolerations_Default(t *testing.T) {
+	assert.Equal(t, []corev1.Toleration{SpotToleration}, getTolerations())
+}
+
+func TestGetTolerations_FromEnv(t *testing.T) {
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_TOLERATIONS",
+		`[{"key": "workload-class", "value": "batch", "effect": "NoSchedule"}, {"key": "burst", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": 60}]`)
+
+	tolerations := getTolerations()
+
+	assert.Len(t, tolerations, 2)
+	assert.Equal(t, corev1.TolerationOpEqual, tolerations[0].Operator)
+	assert.Equal(t, "batch", tolerations[0].Value)
+	assert.Equal(t, corev1.TolerationOpExists, tolerations[1].Operator)
+	assert.Equal(t, int64(60), *tolerations[1].TolerationSeconds)
+}
+
+func TestGetTolerations_FromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tolerations.yaml")
+	err := os.WriteFile(path, []byte("- key: workload-class\n  operator: Equal\n  value: batch\n  effect: NoSchedule\n"), 0600)
+	assert.NoError(t, err)
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_TOLERATIONS_FILE", path)
+
+	tolerations := getTolerations()
+
+	assert.Equal(t, []corev1.Toleration{{Key: "workload-class", Operator: corev1.TolerationOpEqual, Value: "batch", Effect: corev1.TaintEffectNoSchedule}}, tolerations)
+}
+
+func TestGetTolerations_InvalidFallsBackToDefault(t *testing.T) {
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_TOLERATIONS", `[{"key": "a", "operator": "Exists", "value": "b"}]`)
+
+	assert.Equal(t, []corev1.Toleration{SpotToleration}, getTolerations())
+}
+
+func TestParseTolerations_RejectsUnknownFields(t *testing.T) {
+	_, err := parseTolerations([]byte(`[{"key": "a", "efect": "NoSchedule"}]`))
+	assert.Error(t, err)
+}
+
+func TestGetSpotAffinityWeight(t *testing.T) {
+	assert.Equal(t, int32(100), getSpotAffinityWeight())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_SPOT_AFFINITY_WEIGHT", "30")
+	assert.Equal(t, int32(30), getSpotAffinityWeight())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_SPOT_AFFINITY_WEIGHT", "101")
+	assert.Equal(t, int32(100), getSpotAffinityWeight())
+}
+
+func TestGetEnvBool(t *testing.T) {
+	assert.False(t, getEnvBool("AKS_SPOT_INSTANCE_TOLERATOR_AUDIT_MODE"))
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_AUDIT_MODE", "true")
+	assert.True(t, getEnvBool("AKS_SPOT_INSTANCE_TOLERATOR_AUDIT_MODE"))
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_AUDIT_MODE", "sometimes")
+	assert.False(t, getEnvBool("AKS_SPOT_INSTANCE_TOLERATOR_AUDIT_MODE"))
+}
+
+func TestGetStringList(t *testing.T) {
+	assert.Nil(t, getStringList("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_TAINT_KEYS"))
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_TAINT_KEYS", `["sku", "dedicated"]`)
+	assert.Equal(t, []string{"sku", "dedicated"}, getStringList("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_TAINT_KEYS"))
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_TAINT_KEYS", `{"sku": true}`)
+	assert.Nil(t, getStringList("AKS_SPOT_INSTANCE_TOLERATOR_NODE_POOL_TAINT_KEYS"))
+}
+
+func TestGetNoSpotCapacityMode(t *testing.T) {
+	assert.Equal(t, policy.Mode(""), getNoSpotCapacityMode())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_NO_SPOT_CAPACITY_MODE", "tolerate")
+	assert.Equal(t, policy.ModeTolerate, getNoSpotCapacityMode())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_NO_SPOT_CAPACITY_MODE", "off")
+	assert.Equal(t, policy.ModeSkip, getNoSpotCapacityMode())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_NO_SPOT_CAPACITY_MODE", "require")
+	assert.Equal(t, policy.Mode(""), getNoSpotCapacityMode())
+}
+
+func TestGetKindDefaults(t *testing.T) {
+	assert.Empty(t, getKindDefaults())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_KIND_DEFAULTS", `{"Job": "tolerate", "DaemonSet": "skip", "Pod": "off"}`)
+	assert.Equal(t, map[string]policy.Mode{"Job": policy.ModeTolerate, "DaemonSet": policy.ModeSkip, "Pod": policy.ModeSkip}, getKindDefaults())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_KIND_DEFAULTS", `{"Job": "sometimes"}`)
+	assert.Empty(t, getKindDefaults())
+}
+
+func TestGetRiskModes(t *testing.T) {
+	assert.Empty(t, getRiskModes())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_RISK_MODES", `{"persistentVolumeClaim": "tolerate", "hostNetwork": "skip"}`)
+	assert.Equal(t, map[policy.Risk]policy.Mode{policy.RiskPersistentVolumeClaim: policy.ModeTolerate, policy.RiskHostNetwork: policy.ModeSkip}, getRiskModes())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_RISK_MODES", `{"gpu": "skip"}`)
+	assert.Empty(t, getRiskModes())
+}
+
+func TestGetMinReplicas(t *testing.T) {
+	assert.Equal(t, 0, getMinReplicasForSpot())
+	assert.Equal(t, 0, getMinOnDemandReplicas())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_MIN_REPLICAS_FOR_SPOT", "2")
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_MIN_ON_DEMAND_REPLICAS", "-1")
+	assert.Equal(t, 2, getMinReplicasForSpot())
+	assert.Equal(t, 0, getMinOnDemandReplicas())
+}
+
+func TestGetFallbackAfterSeconds(t *testing.T) {
+	assert.Equal(t, 0, getFallbackAfterSeconds())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_FALLBACK_AFTER_SECONDS", "600")
+	assert.Equal(t, 600, getFallbackAfterSeconds())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_FALLBACK_AFTER_SECONDS", "soon")
+	assert.Equal(t, 0, getFallbackAfterSeconds())
+}
+
+func TestGetRebalance(t *testing.T) {
+	assert.Equal(t, 0, getRebalanceSeconds())
+	assert.Equal(t, 1, getPositiveInt("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_EVICTIONS", 1))
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_SECONDS", "300")
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_EVICTIONS", "3")
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_DISRUPTIONS", "0")
+	assert.Equal(t, 300, getRebalanceSeconds())
+	assert.Equal(t, 3, getPositiveInt("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_EVICTIONS", 1))
+	assert.Equal(t, 5, getPositiveInt("AKS_SPOT_INSTANCE_TOLERATOR_REBALANCE_DISRUPTIONS", 5))
+}
+
+func TestGetScheduledEventsURL(t *testing.T) {
+	assert.Equal(t, "http://169.254.169.254/metadata/scheduledevents?api-version=2020-07-01", getScheduledEventsURL())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_SCHEDULED_EVENTS_URL", "http://localhost:8081/scheduledevents")
+	assert.Equal(t, "http://localhost:8081/scheduledevents", getScheduledEventsURL())
+}
+
+func TestGetTopologySpread(t *testing.T) {
+	assert.Empty(t, getTopologySpread())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_TOPOLOGY_SPREAD", `[{"topologyKey": "topology.kubernetes.io/zone"}, {"topologyKey": "kubernetes.azure.com/scalesetpriority", "maxSkew": 2, "whenUnsatisfiable": "DoNotSchedule"}]`)
+	assert.Equal(t, []corev1.TopologySpreadConstraint{
+		{TopologyKey: "topology.kubernetes.io/zone", MaxSkew: 1, WhenUnsatisfiable: corev1.ScheduleAnyway},
+		{TopologyKey: "kubernetes.azure.com/scalesetpriority", MaxSkew: 2, WhenUnsatisfiable: corev1.DoNotSchedule},
+	}, getTopologySpread())
+
+	t.Setenv("AKS_SPOT_INSTANCE_TOLERATOR_TOPOLOGY_SPREAD", `[{"topologyKey": "zone", "labelSelector": {}}]`)
+	assert.Empty(t, getTopologySpread())
+}
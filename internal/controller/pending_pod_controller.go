@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const pendingPodInterval = 30 * time.Second
+
+// PendingPodController falls back to on-demand nodes for workloads whose pods require spot
+// nodes but stay unschedulable. It marks the top-level owner of such a pod with the fallback
+// annotation, so that the webhook admits new pods without the spot requirement, and deletes
+// the pod to have it recreated. The marker is removed once more spot nodes are schedulable than
+// when the workload fell back.
+type PendingPodController struct {
+	k8sClient k8sClient.K8sClientInterface
+	cache     *k8sClient.Cache
+	config    *config.Config
+	now       func() time.Time
+}
+
+func NewPendingPodController(client k8sClient.K8sClientInterface, cache *k8sClient.Cache, config *config.Config) *PendingPodController {
+	return &PendingPodController{
+		k8sClient: client,
+		cache:     cache,
+		config:    config,
+		now:       time.Now,
+	}
+}
+
+func (pc *PendingPodController) Start(stopCh <-chan struct{}) {
+	runPeriodically("pending pod", pendingPodInterval, stopCh, pc.reconcile)
+}
+
+func (pc *PendingPodController) reconcile(ctx context.Context) error {
+	threshold := time.Second * time.Duration(pc.config.FallbackAfterSeconds)
+
+	ready, err := pc.cache.ReadySpotNodes()
+	if err != nil {
+		return err
+	}
+	if ready > 0 {
+		if err := pc.clearFallbacks(ctx, threshold, ready); err != nil {
+			return err
+		}
+	}
+
+	pods, err := pc.cache.Pods().List(labels.SelectorFromSet(labels.Set{policy.MutatedLabel: "true"}))
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	marked := map[types.UID]bool{}
+	for _, pod := range pods {
+		since, stuck := unschedulableSince(pod)
+		if !stuck || pc.now().Sub(since) < threshold {
+			continue
+		}
+		if err := pc.fallBack(ctx, pod, ready, marked); err != nil {
+			errs = append(errs, fmt.Errorf("pod %s/%s: %w", pod.Namespace, pod.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fallBack marks the top-level owner of the pod with the time and the number of ready spot
+// nodes and deletes the pod. Pods without owner would not be recreated and pods in spot-only
+// namespaces have to wait for spot nodes, both are left pending. Pods created after their
+// owner fell back are left pending as well, deleting them would not end the requirement.
+func (pc *PendingPodController) fallBack(ctx context.Context, pod *v1.Pod, ready int, marked map[types.UID]bool) error {
+	if ns, err := pc.cache.Namespaces().Get(pod.Namespace); err == nil {
+		if placement, _, _ := policy.PlacementFromObject(ns); placement == policy.PlacementSpotOnly {
+			slog.Debug(fmt.Sprintf("Pod %s/%s is unschedulable but its namespace is %s", pod.Namespace, pod.Name, placement))
+			return nil
+		}
+	}
+
+	owners := pc.cache.Owners(pod)
+	if len(owners) == 0 {
+		slog.Debug(fmt.Sprintf("Pod %s/%s is unschedulable but has no owner to fall back", pod.Namespace, pod.Name))
+		return nil
+	}
+	owner := owners[len(owners)-1]
+
+	fellBack, found, _ := policy.FallbackFromObject(owner.Object)
+	if found && !pod.CreationTimestamp.Time.Before(fellBack) {
+		// the pod requires spot nodes although its owner already fell back, a new pod would too
+		slog.Warn(fmt.Sprintf("Pod %s/%s is unschedulable and requires spot nodes although %s %s falls back to on-demand nodes. Not deleting it",
+			pod.Namespace, pod.Name, owner.Kind, owner.Object.GetName()))
+		return nil
+	}
+	if !found && !marked[owner.Object.GetUID()] {
+		since, nodes := pc.now().UTC().Format(time.RFC3339), strconv.Itoa(ready)
+		if err := patchAnnotations(ctx, pc.k8sClient.Clientset(), owner, map[string]*string{
+			policy.FallbackAnnotation:          &since,
+			policy.FallbackSpotNodesAnnotation: &nodes,
+		}); err != nil {
+			return fmt.Errorf("could not mark %s %s: %w", owner.Kind, owner.Object.GetName(), err)
+		}
+		slog.Info(fmt.Sprintf("%s %s/%s falls back to on-demand nodes. Pod %s is unschedulable on spot nodes",
+			owner.Kind, owner.Object.GetNamespace(), owner.Object.GetName(), pod.Name))
+	}
+	marked[owner.Object.GetUID()] = true
+
+	uid := pod.UID
+	err := pc.k8sClient.Clientset().CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &uid},
+	})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("could not delete pod: %w", err)
+	}
+	slog.Info(fmt.Sprintf("Deleted unschedulable pod %s/%s to be recreated without spot requirement", pod.Namespace, pod.Name))
+	return nil
+}
+
+// clearFallbacks removes the fallback marker from workloads that fell back at least the
+// threshold ago and since then gained ready spot nodes, so that new pods require spot nodes
+// again. Waiting for the threshold keeps workloads from switching back and forth while spot
+// nodes come and go. Workloads that fell back while spot nodes were ready but full stay on
+// on-demand nodes until the spot capacity grows.
+func (pc *PendingPodController) clearFallbacks(ctx context.Context, threshold time.Duration, ready int) error {
+	workloads, err := pc.cache.Workloads()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, workload := range workloads {
+		since, found, err := policy.FallbackFromObject(workload.Object)
+		if !found || (err == nil && pc.now().Sub(since) < threshold) {
+			continue
+		}
+		if nodes, recorded := policy.FallbackSpotNodesFromObject(workload.Object); recorded && ready <= nodes {
+			continue
+		}
+
+		if err := patchAnnotations(ctx, pc.k8sClient.Clientset(), workload, map[string]*string{
+			policy.FallbackAnnotation:          nil,
+			policy.FallbackSpotNodesAnnotation: nil,
+		}); err != nil {
+			errs = append(errs, fmt.Errorf("could not clear fallback of %s %s/%s: %w", workload.Kind, workload.Object.GetNamespace(), workload.Object.GetName(), err))
+			continue
+		}
+		slog.Info(fmt.Sprintf("Spot nodes are schedulable again. %s %s/%s no longer falls back to on-demand nodes",
+			workload.Kind, workload.Object.GetNamespace(), workload.Object.GetName()))
+	}
+	return errors.Join(errs...)
+}
+
+// unschedulableSince returns since when the pod, requiring spot nodes, could not be scheduled.
+func unschedulableSince(pod *v1.Pod) (time.Time, bool) {
+	if pod.Spec.NodeName != "" || pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodPending || !requiresSpot(pod) {
+		return time.Time{}, false
+	}
+
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == v1.PodScheduled && condition.Status == v1.ConditionFalse && condition.Reason == v1.PodReasonUnschedulable {
+			return condition.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// requiresSpot reports whether the required node affinity of the pod only matches spot nodes.
+// The recorded decision is not enough, the requirement may have been refused on admission.
+func requiresSpot(pod *v1.Pod) bool {
+	if pod.Spec.Affinity == nil || pod.Spec.Affinity.NodeAffinity == nil {
+		return false
+	}
+	required := pod.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		return false
+	}
+	for _, term := range required.NodeSelectorTerms {
+		if !slices.ContainsFunc(term.MatchExpressions, func(expression v1.NodeSelectorRequirement) bool {
+			return expression.Key == config.SpotNodeLabelKey && expression.Operator == v1.NodeSelectorOpIn &&
+				len(expression.Values) > 0 && !slices.ContainsFunc(expression.Values, func(value string) bool { return value != config.SpotNodeLabelValue })
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+package controller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var fallbackNow = time.Date(2024, 10, 2, 12, 0, 0, 0, time.UTC)
+
+func controllerRef(apiVersion, kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(name), Controller: &controller}}
+}
+
+func deployment(name string, annotations map[string]string) []runtime.Object {
+	return []runtime.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", UID: types.UID(name), Annotations: annotations}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: name + "-1", Namespace: "ns", UID: types.UID(name + "-1"),
+			OwnerReferences: controllerRef("apps/v1", "Deployment", name)}},
+	}
+}
+
+// pendingPod returns a pod admitted with the mode. Pods admitted to require spot nodes get the
+// required node affinity.
+func pendingPod(name string, owner string, mode policy.Mode, unschedulableFor time.Duration) *v1.Pod {
+	decision := policy.Decision{Mode: mode, Reason: "default mode"}
+	var affinity *v1.Affinity
+	if mode == policy.ModeRequire {
+		affinity = &v1.Affinity{NodeAffinity: &v1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &v1.NodeSelector{
+			NodeSelectorTerms: []v1.NodeSelectorTerm{{MatchExpressions: []v1.NodeSelectorRequirement{
+				{Key: config.SpotNodeLabelKey, Operator: v1.NodeSelectorOpIn, Values: []string{config.SpotNodeLabelValue}},
+			}}},
+		}}}
+	}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", UID: types.UID(name),
+			Labels:          map[string]string{policy.MutatedLabel: "true"},
+			Annotations:     map[string]string{policy.DecisionAnnotation: decision.Annotation()},
+			OwnerReferences: controllerRef("apps/v1", "ReplicaSet", owner+"-1")},
+		Spec: v1.PodSpec{Affinity: affinity},
+		Status: v1.PodStatus{
+			Phase: v1.PodPending,
+			Conditions: []v1.PodCondition{{Type: v1.PodScheduled, Status: v1.ConditionFalse, Reason: v1.PodReasonUnschedulable,
+				LastTransitionTime: metav1.NewTime(fallbackNow.Add(-unschedulableFor))}},
+		},
+	}
+}
+
+func readySpotNode(name string) *v1.Node {
+	return &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{config.SpotNodeLabelKey: config.SpotNodeLabelValue}},
+		Status: v1.NodeStatus{
+			Allocatable: v1.ResourceList{v1.ResourceCPU: resource.MustParse("2"), v1.ResourceMemory: resource.MustParse("8Gi"), v1.ResourcePods: resource.MustParse("110")},
+			Conditions:  []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+func newPendingPodController(t *testing.T, objects ...runtime.Object) (*PendingPodController, *MockK8sClient) {
+	cfg := config.NewConfig()
+	cfg.FallbackAfterSeconds = 600
+
+	client := NewMockK8sClient(objects...)
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	cache := k8sClient.NewCache(client, 0)
+	assert.NoError(t, cache.Start(stopCh))
+
+	controller := NewPendingPodController(client, cache, cfg)
+	controller.now = func() time.Time { return fallbackNow }
+	return controller, client
+}
+
+func TestPendingPodController_FallsBack(t *testing.T) {
+	objects := append(deployment("web", nil), pendingPod("web-1-a", "web", policy.ModeRequire, 15*time.Minute))
+	controller, client := newPendingPodController(t, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	web, err := client.Clientset().AppsV1().Deployments("ns").Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-10-02T12:00:00Z", web.Annotations[policy.FallbackAnnotation])
+	assert.Equal(t, "0", web.Annotations[policy.FallbackSpotNodesAnnotation])
+
+	_, err = client.Clientset().CoreV1().Pods("ns").Get(context.TODO(), "web-1-a", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestPendingPodController_IgnoresOtherPods(t *testing.T) {
+	objects := append(deployment("web", nil),
+		pendingPod("recent", "web", policy.ModeRequire, 5*time.Minute),
+		pendingPod("preferring", "web", policy.ModePrefer, 15*time.Minute),
+	)
+	bare := pendingPod("bare", "web", policy.ModeRequire, 15*time.Minute)
+	bare.OwnerReferences = nil
+	refused := pendingPod("refused", "web", policy.ModeRequire, 15*time.Minute)
+	refused.Spec.Affinity = nil
+	objects = append(objects, bare, refused)
+	controller, client := newPendingPodController(t, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	web, err := client.Clientset().AppsV1().Deployments("ns").Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, web.Annotations, policy.FallbackAnnotation)
+
+	pods, err := client.Clientset().CoreV1().Pods("ns").List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, pods.Items, 4)
+}
+
+func TestPendingPodController_KeepsPodsCreatedAfterTheFallback(t *testing.T) {
+	objects := deployment("web", map[string]string{policy.FallbackAnnotation: "2024-10-02T11:00:00Z"})
+	recreated := pendingPod("web-1-b", "web", policy.ModeRequire, 15*time.Minute)
+	recreated.CreationTimestamp = metav1.NewTime(fallbackNow.Add(-30 * time.Minute))
+	objects = append(objects, recreated, pendingPod("web-1-a", "web", policy.ModeRequire, 15*time.Minute))
+	controller, client := newPendingPodController(t, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	_, err := client.Clientset().CoreV1().Pods("ns").Get(context.TODO(), "web-1-b", metav1.GetOptions{})
+	assert.NoError(t, err)
+	_, err = client.Clientset().CoreV1().Pods("ns").Get(context.TODO(), "web-1-a", metav1.GetOptions{})
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestPendingPodController_KeepsSpotOnlyNamespacesPending(t *testing.T) {
+	objects := append(deployment("web", nil), pendingPod("web-1-a", "web", policy.ModeRequire, 15*time.Minute),
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: map[string]string{policy.PlacementAnnotation: "spot-only"}}})
+	controller, client := newPendingPodController(t, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	_, err := client.Clientset().CoreV1().Pods("ns").Get(context.TODO(), "web-1-a", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestPendingPodController_ClearsFallback(t *testing.T) {
+	objects := append(deployment("old", map[string]string{policy.FallbackAnnotation: "2024-10-02T11:00:00Z"}),
+		deployment("recent", map[string]string{policy.FallbackAnnotation: "2024-10-02T11:55:00Z"})...)
+	objects = append(objects, readySpotNode("spot-1"))
+	controller, client := newPendingPodController(t, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	old, err := client.Clientset().AppsV1().Deployments("ns").Get(context.TODO(), "old", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, old.Annotations, policy.FallbackAnnotation)
+
+	recent, err := client.Clientset().AppsV1().Deployments("ns").Get(context.TODO(), "recent", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, recent.Annotations, policy.FallbackAnnotation)
+}
+
+func TestPendingPodController_KeepsFallbackWithoutSpotNodes(t *testing.T) {
+	controller, client := newPendingPodController(t, deployment("old", map[string]string{policy.FallbackAnnotation: "2024-10-02T11:00:00Z"})...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	old, err := client.Clientset().AppsV1().Deployments("ns").Get(context.TODO(), "old", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, old.Annotations, policy.FallbackAnnotation)
+}
+
+func TestPendingPodController_KeepsFallbackUntilSpotCapacityGrows(t *testing.T) {
+	marked := map[string]string{policy.FallbackAnnotation: "2024-10-02T11:00:00Z", policy.FallbackSpotNodesAnnotation: "1"}
+	objects := append(deployment("web", marked), readySpotNode("spot-1"))
+	controller, client := newPendingPodController(t, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	web, err := client.Clientset().AppsV1().Deployments("ns").Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Contains(t, web.Annotations, policy.FallbackAnnotation)
+
+	objects = append(deployment("web", marked), readySpotNode("spot-1"), readySpotNode("spot-2"))
+	controller, client = newPendingPodController(t, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	web, err = client.Clientset().AppsV1().Deployments("ns").Get(context.TODO(), "web", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.NotContains(t, web.Annotations, policy.FallbackAnnotation)
+	assert.NotContains(t, web.Annotations, policy.FallbackSpotNodesAnnotation)
+}
@@ -0,0 +1,21 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// runPeriodically starts calling reconcile every interval until the stop channel is closed.
+// Errors are logged, the next run retries.
+func runPeriodically(name string, interval time.Duration, stopCh <-chan struct{}, reconcile func(context.Context) error) {
+	slog.Info(fmt.Sprintf("Starting %s controller", name))
+	go wait.Until(func() {
+		if err := reconcile(context.TODO()); err != nil {
+			slog.Error(fmt.Sprintf("The %s controller failed to reconcile. %v", name, err))
+		}
+	}, interval, stopCh)
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/util/retry"
+)
+
+// PreemptedTaintKey is the taint set on spot nodes that are about to be preempted.
+const PreemptedTaintKey = "spot-tolerator.stein.solutions/preempted"
+
+const (
+	preemptionInterval = time.Second
+	// preemptionTimeout is the time the drain of a node may take. Azure announces the
+	// preemption of spot instances at least 30 seconds in advance.
+	preemptionTimeout = 30 * time.Second
+	// maxPreemptionGracePeriod caps the termination grace period of evicted pods, so that they
+	// stop before the node is gone.
+	maxPreemptionGracePeriod int64 = 25
+)
+
+// PreemptionController runs on every spot node and polls the scheduled events of the virtual
+// machine. Once a Preempt event announces the eviction of the spot instance, the node is
+// cordoned, tainted and its pods are evicted, so that they terminate gracefully and are
+// recreated elsewhere before the node is gone.
+type PreemptionController struct {
+	k8sClient  k8sClient.K8sClientInterface
+	config     *config.Config
+	httpClient *http.Client
+	handled    map[string]bool
+}
+
+func NewPreemptionController(client k8sClient.K8sClientInterface, config *config.Config) *PreemptionController {
+	return &PreemptionController{
+		k8sClient:  client,
+		config:     config,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		handled:    map[string]bool{},
+	}
+}
+
+func (pc *PreemptionController) Start(stopCh <-chan struct{}) {
+	runPeriodically("preemption", preemptionInterval, stopCh, pc.reconcile)
+}
+
+func (pc *PreemptionController) reconcile(ctx context.Context) error {
+	events, err := getScheduledEvents(ctx, pc.httpClient, pc.config.ScheduledEventsURL)
+	if err != nil {
+		return fmt.Errorf("could not get scheduled events: %w", err)
+	}
+
+	for _, event := range events.Events {
+		if event.EventType != preemptEventType || pc.handled[event.EventId] || !event.affects(pc.config.NodeName) {
+			continue
+		}
+
+		slog.Info(fmt.Sprintf("Node %s is preempted not before %q. Draining the node", pc.config.NodeName, event.NotBefore))
+		drainCtx, cancel := context.WithTimeout(ctx, preemptionTimeout)
+		err := pc.drain(drainCtx)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("could not drain node %s: %w", pc.config.NodeName, err)
+		}
+		pc.handled[event.EventId] = true
+		slog.Info(fmt.Sprintf("Drained preempted node %s", pc.config.NodeName))
+	}
+	return nil
+}
+
+func (pc *PreemptionController) drain(ctx context.Context) error {
+	if err := pc.cordon(ctx); err != nil {
+		return err
+	}
+
+	pods, err := pc.k8sClient.Clientset().CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", pc.config.NodeName).String(),
+	})
+	if err != nil {
+		return fmt.Errorf("could not list pods: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	errs := []error{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName != pc.config.NodeName || !evictable(pod) {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := pc.evict(ctx, pod); err != nil {
+				lock.Lock()
+				errs = append(errs, fmt.Errorf("pod %s/%s: %w", pod.Namespace, pod.Name, err))
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// cordon marks the node unschedulable and taints it. The taint tells other components, e.g.
+// the node cache of the webhook, that the node is going away.
+func (pc *PreemptionController) cordon(ctx context.Context) error {
+	nodes := pc.k8sClient.Clientset().CoreV1().Nodes()
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := nodes.Get(ctx, pc.config.NodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		taint := v1.Taint{Key: PreemptedTaintKey, Effect: v1.TaintEffectNoSchedule, TimeAdded: &metav1.Time{Time: time.Now()}}
+		tainted := slices.ContainsFunc(node.Spec.Taints, func(t v1.Taint) bool { return t.MatchTaint(&taint) })
+		if node.Spec.Unschedulable && tainted {
+			return nil
+		}
+
+		node.Spec.Unschedulable = true
+		if !tainted {
+			node.Spec.Taints = append(node.Spec.Taints, taint)
+		}
+		_, err = nodes.Update(ctx, node, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// evict evicts the pod with a grace period that ends before the node is gone. Evictions denied
+// by a PodDisruptionBudget fail the drain, which is retried on the next poll until the node is
+// gone.
+func (pc *PreemptionController) evict(ctx context.Context, pod *v1.Pod) error {
+	gracePeriod := maxPreemptionGracePeriod
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		gracePeriod = min(gracePeriod, *pod.Spec.TerminationGracePeriodSeconds)
+	}
+	options := metav1.DeleteOptions{GracePeriodSeconds: &gracePeriod, Preconditions: &metav1.Preconditions{UID: &pod.UID}}
+
+	err := pc.k8sClient.Clientset().PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+		ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+		DeleteOptions: &options,
+	})
+	if apierrors.IsTooManyRequests(err) {
+		return fmt.Errorf("eviction is denied by the disruption budget of the pod")
+	}
+	if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+		return err
+	}
+	return nil
+}
+
+// evictable reports whether the pod has to be evicted from the node. Pods of DaemonSets would
+// be recreated on the node and static pods cannot be evicted.
+func evictable(pod *v1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+		return false
+	}
+	if _, mirror := pod.Annotations[v1.MirrorPodAnnotationKey]; mirror {
+		return false
+	}
+	if owner := metav1.GetControllerOf(pod); owner != nil && owner.Kind == "DaemonSet" {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const preemptEvent = `{"DocumentIncarnation": 2, "Events": [{"EventId": "602d9444-d2cd-49c7-8624-8643e7171297",
+	"EventType": "Preempt", "ResourceType": "VirtualMachine", "Resources": ["aks-spot-12345-vmss_10"],
+	"EventStatus": "Scheduled", "NotBefore": "Mon, 19 Sep 2016 18:29:47 GMT"}]}`
+
+func scheduledEventsServer(t *testing.T, body string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func podOn(name string, node string, owner []metav1.OwnerReference) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", OwnerReferences: owner},
+		Spec:       v1.PodSpec{NodeName: node},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+}
+
+// newPreemptionController records the evicted pods. Evictions of pods listed in denied are
+// rejected like the API server does for pods protected by a PodDisruptionBudget.
+func newPreemptionController(t *testing.T, body string, denied []string, objects ...runtime.Object) (*PreemptionController, *MockK8sClient, func() []string) {
+	cfg := config.NewConfig()
+	cfg.NodeName = "aks-spot-12345-vmss00000a"
+	cfg.ScheduledEventsURL = scheduledEventsServer(t, body).URL
+
+	client := NewMockK8sClient(objects...)
+	var lock sync.Mutex
+	evicted := []string{}
+	client.Clientset().(*fake.Clientset).PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		for _, name := range denied {
+			if name == eviction.Name {
+				return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+			}
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		evicted = append(evicted, eviction.Name)
+		return true, nil, nil
+	})
+
+	return NewPreemptionController(client, cfg), client, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		sort.Strings(evicted)
+		return evicted
+	}
+}
+
+func TestPreemptionController_DrainsPreemptedNode(t *testing.T) {
+	controller, client, evicted := newPreemptionController(t, preemptEvent, []string{"protected"},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "aks-spot-12345-vmss00000a"}},
+		podOn("web", "aks-spot-12345-vmss00000a", controllerRef("apps/v1", "ReplicaSet", "web-1")),
+		podOn("protected", "aks-spot-12345-vmss00000a", nil),
+		podOn("agent", "aks-spot-12345-vmss00000a", controllerRef("apps/v1", "DaemonSet", "agent")),
+		podOn("other", "aks-spot-12345-vmss00000b", nil),
+	)
+
+	assert.ErrorContains(t, controller.reconcile(context.TODO()), "pod ns/protected: eviction is denied by the disruption budget of the pod")
+
+	node, err := client.Clientset().CoreV1().Nodes().Get(context.TODO(), "aks-spot-12345-vmss00000a", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.True(t, node.Spec.Unschedulable)
+	assert.Len(t, node.Spec.Taints, 1)
+	assert.Equal(t, PreemptedTaintKey, node.Spec.Taints[0].Key)
+	assert.Equal(t, v1.TaintEffectNoSchedule, node.Spec.Taints[0].Effect)
+
+	assert.Equal(t, []string{"web"}, evicted())
+	_, err = client.Clientset().CoreV1().Pods("ns").Get(context.TODO(), "protected", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestPreemptionController_DrainsOnce(t *testing.T) {
+	controller, _, evicted := newPreemptionController(t, preemptEvent, nil,
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "aks-spot-12345-vmss00000a"}},
+		podOn("web", "aks-spot-12345-vmss00000a", controllerRef("apps/v1", "ReplicaSet", "web-1")),
+	)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Equal(t, []string{"web"}, evicted())
+}
+
+func TestPreemptionController_IgnoresOtherEvents(t *testing.T) {
+	events := `{"DocumentIncarnation": 3, "Events": [
+		{"EventId": "1", "EventType": "Preempt", "Resources": ["aks-spot-12345-vmss_11"]},
+		{"EventId": "2", "EventType": "Reboot", "Resources": ["aks-spot-12345-vmss_10"]}]}`
+	controller, client, evicted := newPreemptionController(t, events, nil,
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "aks-spot-12345-vmss00000a"}},
+		podOn("web", "aks-spot-12345-vmss00000a", nil),
+	)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+
+	node, err := client.Clientset().CoreV1().Nodes().Get(context.TODO(), "aks-spot-12345-vmss00000a", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.False(t, node.Spec.Unschedulable)
+	assert.Empty(t, evicted())
+}
+
+func TestPreemptionController_FailsOnUnavailableMetadataService(t *testing.T) {
+	controller, _, _ := newPreemptionController(t, "", nil)
+	controller.config.ScheduledEventsURL = "http://127.0.0.1:1/metadata/scheduledevents"
+
+	assert.Error(t, controller.reconcile(context.TODO()))
+}
+
+func TestScaleSetInstanceName(t *testing.T) {
+	assert.Equal(t, "aks-spot-12345-vmss_10", scaleSetInstanceName("aks-spot-12345-vmss00000a"))
+	assert.Equal(t, "aks-spot-12345-vmss_0", scaleSetInstanceName("aks-spot-12345-vmss000000"))
+	assert.Equal(t, "worker-1", scaleSetInstanceName("worker-1"))
+	assert.Equal(t, "aks-spot-12345-vmss", scaleSetInstanceName("aks-spot-12345-vmss"))
+}
@@ -0,0 +1,276 @@
+package controller
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RebalanceController moves pods preferring spot nodes back to spot nodes. Such pods end up
+// on on-demand nodes while spot capacity is missing and stay there once it returns. While spot
+// nodes are schedulable, the controller evicts a limited number of them per run, so that they
+// are recreated and scheduled on spot nodes.
+type RebalanceController struct {
+	k8sClient k8sClient.K8sClientInterface
+	cache     *k8sClient.Cache
+	config    *config.Config
+}
+
+func NewRebalanceController(client k8sClient.K8sClientInterface, cache *k8sClient.Cache, config *config.Config) *RebalanceController {
+	return &RebalanceController{
+		k8sClient: client,
+		cache:     cache,
+		config:    config,
+	}
+}
+
+func (rc *RebalanceController) Start(stopCh <-chan struct{}) {
+	runPeriodically("rebalance", time.Second*time.Duration(rc.config.RebalanceSeconds), stopCh, rc.reconcile)
+}
+
+// reconcile evicts at most RebalanceEvictions pods and only as long as less than
+// RebalanceDisruptions mutated pods are terminating or waiting to be scheduled. At most one pod
+// per workload is evicted per run. Evictions denied by a PodDisruptionBudget are retried in
+// the next run. Ready spot nodes do not mean free spot capacity: pods are only evicted while
+// no pod waits for spot nodes and their requests fit on a spot node, so that full spot nodes
+// do not send them back to on-demand nodes run after run.
+func (rc *RebalanceController) reconcile(ctx context.Context) error {
+	ready, err := rc.cache.ReadySpotNodes()
+	if err != nil {
+		return err
+	}
+	if ready == 0 {
+		return nil
+	}
+
+	pods, err := rc.cache.Pods().List(labels.SelectorFromSet(labels.Set{policy.MutatedLabel: "true"}))
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(pods, func(a, b *v1.Pod) int {
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+	})
+
+	budget := rc.config.RebalanceDisruptions
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == v1.PodPending {
+			budget--
+		}
+	}
+	budget = min(budget, rc.config.RebalanceEvictions)
+	if budget <= 0 {
+		slog.Debug("Not rebalancing pods, the disruption budget is exhausted")
+		return nil
+	}
+	if waiting := waitingForSpot(pods); waiting > 0 {
+		slog.Debug(fmt.Sprintf("Not rebalancing pods, %d pods are waiting for spot nodes", waiting))
+		return nil
+	}
+
+	capacity, err := rc.freeSpotCapacity()
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	evicted := map[types.UID]bool{}
+	for _, pod := range pods {
+		if budget == 0 {
+			break
+		}
+		owner, ok := rc.movable(pod)
+		if !ok || evicted[owner.Object.GetUID()] {
+			continue
+		}
+		node := capacity.fit(pod)
+		if node == nil {
+			slog.Debug(fmt.Sprintf("Not evicting pod %s/%s, it does not fit on a spot node", pod.Namespace, pod.Name))
+			continue
+		}
+
+		err := rc.k8sClient.Clientset().PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{
+			ObjectMeta:    metav1.ObjectMeta{Name: pod.Name, Namespace: pod.Namespace},
+			DeleteOptions: &metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &pod.UID}},
+		})
+		switch {
+		case apierrors.IsTooManyRequests(err):
+			slog.Debug(fmt.Sprintf("Eviction of pod %s/%s is denied by its disruption budget", pod.Namespace, pod.Name))
+			continue
+		case apierrors.IsNotFound(err) || apierrors.IsConflict(err):
+			continue
+		case err != nil:
+			errs = append(errs, fmt.Errorf("could not evict pod %s/%s: %w", pod.Namespace, pod.Name, err))
+			continue
+		}
+		slog.Info(fmt.Sprintf("Evicted pod %s/%s from on-demand node %s to be rescheduled on spot nodes", pod.Namespace, pod.Name, pod.Spec.NodeName))
+		evicted[owner.Object.GetUID()] = true
+		node.reserve(pod)
+		budget--
+	}
+	return errors.Join(errs...)
+}
+
+// movable returns the replicated owner of a running pod that was admitted to prefer spot
+// nodes but runs on an on-demand node. Pods without such an owner would not be recreated and
+// pods keeping their workload at the minimum of on-demand replicas would come back to an
+// on-demand node, both are left in place.
+func (rc *RebalanceController) movable(pod *v1.Pod) (k8sClient.Owner, bool) {
+	if pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodRunning || pod.Spec.NodeName == "" {
+		return k8sClient.Owner{}, false
+	}
+	if mode, found := policy.RecordedMode(pod); !found || mode != policy.ModePrefer {
+		return k8sClient.Owner{}, false
+	}
+	node, err := rc.cache.Nodes().Get(pod.Spec.NodeName)
+	if err != nil || k8sClient.IsSpotNode(node) {
+		return k8sClient.Owner{}, false
+	}
+
+	owner, _, found := k8sClient.ReplicatedOwner(rc.cache.Owners(pod))
+	if !found {
+		return k8sClient.Owner{}, false
+	}
+
+	if rc.config.MinOnDemandReplicas > 0 {
+		onDemand, err := rc.cache.OnDemandReplicas(owner)
+		if err != nil || onDemand <= rc.config.MinOnDemandReplicas {
+			return k8sClient.Owner{}, false
+		}
+	}
+	return owner, true
+}
+
+// waitingForSpot returns the number of mutated pods targeting spot nodes that are not scheduled.
+func waitingForSpot(pods []*v1.Pod) int {
+	waiting := 0
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != v1.PodPending || pod.Spec.NodeName != "" {
+			continue
+		}
+		if mode, found := policy.RecordedMode(pod); found && (mode == policy.ModePrefer || mode == policy.ModeRequire) {
+			waiting++
+		}
+	}
+	return waiting
+}
+
+// spotNode is a schedulable spot node with the resources left by the requests of its pods.
+type spotNode struct {
+	node *v1.Node
+	free v1.ResourceList
+}
+
+type spotCapacity []*spotNode
+
+// freeSpotCapacity returns the schedulable spot nodes ordered by name with their free resources.
+func (rc *RebalanceController) freeSpotCapacity() (spotCapacity, error) {
+	nodes, err := rc.cache.Nodes().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	capacity := spotCapacity{}
+	byName := map[string]*spotNode{}
+	for _, node := range nodes {
+		if k8sClient.IsSpotNode(node) && k8sClient.IsSchedulable(node) {
+			spot := &spotNode{node: node, free: node.Status.Allocatable.DeepCopy()}
+			capacity = append(capacity, spot)
+			byName[node.Name] = spot
+		}
+	}
+	slices.SortFunc(capacity, func(a, b *spotNode) int { return cmp.Compare(a.node.Name, b.node.Name) })
+
+	pods, err := rc.cache.Pods().List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods {
+		if spot, exists := byName[pod.Spec.NodeName]; exists && pod.Status.Phase != v1.PodSucceeded && pod.Status.Phase != v1.PodFailed {
+			spot.reserve(pod)
+		}
+	}
+	return capacity, nil
+}
+
+// fit returns the first spot node whose taints the pod tolerates and whose free resources
+// cover the requests of the pod. Node affinity and topology are left to the scheduler.
+func (c spotCapacity) fit(pod *v1.Pod) *spotNode {
+	requests := podRequests(pod)
+	for _, spot := range c {
+		if !toleratesTaints(pod, spot.node.Spec.Taints) {
+			continue
+		}
+		fits := true
+		for name, requested := range requests {
+			if free, exists := spot.free[name]; !exists || free.Cmp(requested) < 0 {
+				fits = false
+				break
+			}
+		}
+		if fits {
+			return spot
+		}
+	}
+	return nil
+}
+
+func (n *spotNode) reserve(pod *v1.Pod) {
+	for name, requested := range podRequests(pod) {
+		if free, exists := n.free[name]; exists {
+			free.Sub(requested)
+			n.free[name] = free
+		}
+	}
+}
+
+// podRequests returns the resources the scheduler reserves for the pod: the requests of its
+// containers or of its largest init container, its overhead and one pod slot.
+func podRequests(pod *v1.Pod) v1.ResourceList {
+	requests := v1.ResourceList{v1.ResourcePods: resource.MustParse("1")}
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			sum := requests[name]
+			sum.Add(quantity)
+			requests[name] = sum
+		}
+	}
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if current, exists := requests[name]; !exists || quantity.Cmp(current) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	for name, quantity := range pod.Spec.Overhead {
+		sum := requests[name]
+		sum.Add(quantity)
+		requests[name] = sum
+	}
+	return requests
+}
+
+func toleratesTaints(pod *v1.Pod, taints []v1.Taint) bool {
+	for i := range taints {
+		if taints[i].Effect == v1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !slices.ContainsFunc(pod.Spec.Tolerations, func(toleration v1.Toleration) bool { return toleration.ToleratesTaint(&taints[i]) }) {
+			return false
+		}
+	}
+	return true
+}
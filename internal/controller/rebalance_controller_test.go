@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func runningPod(name string, owner string, mode policy.Mode, node string) *v1.Pod {
+	pod := pendingPod(name, owner, mode, 0)
+	pod.Spec.NodeName = node
+	pod.Status = v1.PodStatus{Phase: v1.PodRunning}
+	return pod
+}
+
+func onDemandNode(name string) *v1.Node {
+	node := readySpotNode(name)
+	node.Labels = nil
+	return node
+}
+
+// newRebalanceController records the evicted pods. Evictions of pods listed in denied are
+// rejected like the API server does for pods protected by a PodDisruptionBudget.
+func newRebalanceController(t *testing.T, cfg *config.Config, denied []string, objects ...runtime.Object) (*RebalanceController, *[]string) {
+	client := NewMockK8sClient(objects...)
+	evicted := []string{}
+	client.Clientset().(*fake.Clientset).PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(*policyv1.Eviction)
+		for _, name := range denied {
+			if name == eviction.Name {
+				return true, nil, apierrors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 0)
+			}
+		}
+		evicted = append(evicted, eviction.Name)
+		return true, nil, nil
+	})
+
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+	cache := k8sClient.NewCache(client, 0)
+	assert.NoError(t, cache.Start(stopCh))
+
+	return NewRebalanceController(client, cache, cfg), &evicted
+}
+
+func rebalanceConfig() *config.Config {
+	cfg := config.NewConfig()
+	cfg.RebalanceSeconds = 300
+	cfg.RebalanceEvictions = 10
+	cfg.RebalanceDisruptions = 10
+	return cfg
+}
+
+func TestRebalanceController_EvictsPodsOnOnDemandNodes(t *testing.T) {
+	objects := append(deployment("web", nil), deployment("api", nil)...)
+	objects = append(objects, readySpotNode("spot-1"), onDemandNode("on-demand-1"),
+		runningPod("web-1-a", "web", policy.ModePrefer, "on-demand-1"),
+		runningPod("web-1-b", "web", policy.ModePrefer, "on-demand-1"),
+		runningPod("api-1-a", "api", policy.ModePrefer, "spot-1"),
+		runningPod("api-1-b", "api", policy.ModeRequire, "on-demand-1"),
+	)
+	controller, evicted := newRebalanceController(t, rebalanceConfig(), nil, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Equal(t, []string{"web-1-a"}, *evicted)
+}
+
+func TestRebalanceController_KeepsPodsWithoutSpotNodes(t *testing.T) {
+	objects := append(deployment("web", nil), onDemandNode("on-demand-1"),
+		runningPod("web-1-a", "web", policy.ModePrefer, "on-demand-1"))
+	controller, evicted := newRebalanceController(t, rebalanceConfig(), nil, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Empty(t, *evicted)
+}
+
+func TestRebalanceController_RespectsDisruptionBudgets(t *testing.T) {
+	objects := append(deployment("web", nil), deployment("api", nil)...)
+	objects = append(objects, deployment("db", nil)...)
+	objects = append(objects, readySpotNode("spot-1"), onDemandNode("on-demand-1"),
+		runningPod("api-1-a", "api", policy.ModePrefer, "on-demand-1"),
+		runningPod("db-1-a", "db", policy.ModePrefer, "on-demand-1"),
+		runningPod("web-1-a", "web", policy.ModePrefer, "on-demand-1"),
+	)
+
+	controller, evicted := newRebalanceController(t, rebalanceConfig(), []string{"api-1-a"}, objects...)
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Equal(t, []string{"db-1-a", "web-1-a"}, *evicted)
+
+	cfg := rebalanceConfig()
+	cfg.RebalanceEvictions = 1
+	controller, evicted = newRebalanceController(t, cfg, nil, objects...)
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Equal(t, []string{"api-1-a"}, *evicted)
+
+	cfg = rebalanceConfig()
+	cfg.RebalanceDisruptions = 2
+	terminating := runningPod("web-1-b", "web", policy.ModePrefer, "spot-1")
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	terminating.Finalizers = []string{"test"}
+	objects = append(objects, terminating)
+	controller, evicted = newRebalanceController(t, cfg, nil, objects...)
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Equal(t, []string{"api-1-a"}, *evicted)
+}
+
+func TestRebalanceController_KeepsPodsWhileSpotNodesAreFull(t *testing.T) {
+	objects := append(deployment("web", nil), readySpotNode("spot-1"), onDemandNode("on-demand-1"),
+		runningPod("web-1-a", "web", policy.ModePrefer, "on-demand-1"),
+		pendingPod("web-1-b", "web", policy.ModePrefer, time.Minute))
+	controller, evicted := newRebalanceController(t, rebalanceConfig(), nil, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Empty(t, *evicted)
+
+	requesting := func(pod *v1.Pod, cpu string) *v1.Pod {
+		pod.Spec.Containers = []v1.Container{{Name: "app", Resources: v1.ResourceRequirements{
+			Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse(cpu)},
+		}}}
+		return pod
+	}
+	objects = append(deployment("web", nil), deployment("api", nil)...)
+	objects = append(objects, readySpotNode("spot-1"), onDemandNode("on-demand-1"),
+		requesting(runningPod("db-1-a", "db", policy.ModeTolerate, "spot-1"), "1"),
+		requesting(runningPod("api-1-a", "api", policy.ModePrefer, "on-demand-1"), "500m"),
+		requesting(runningPod("web-1-a", "web", policy.ModePrefer, "on-demand-1"), "1500m"),
+	)
+	controller, evicted = newRebalanceController(t, rebalanceConfig(), nil, objects...)
+
+	assert.NoError(t, controller.reconcile(context.TODO()))
+	assert.Equal(t, []string{"api-1-a"}, *evicted)
+}
+
+func TestRebalanceController_SurfacesEvictionErrors(t *testing.T) {
+	objects := append(deployment("web", nil), readySpotNode("spot-1"), onDemandNode("on-demand-1"),
+		runningPod("web-1-a", "web", policy.ModePrefer, "on-demand-1"))
+	controller, _ := newRebalanceController(t, rebalanceConfig(), nil, objects...)
+	controller.k8sClient.Clientset().(*fake.Clientset).PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierrors.NewGenericServerResponse(http.StatusInternalServerError, "create", v1.Resource("pods"), "web-1-a", "", 0, false)
+	})
+
+	assert.Error(t, controller.reconcile(context.TODO()))
+}
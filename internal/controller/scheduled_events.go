@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const preemptEventType = "Preempt"
+
+// scheduledEvents is the response of the Azure Instance Metadata Service for scheduled events.
+// See https://learn.microsoft.com/en-us/azure/virtual-machines/linux/scheduled-events
+type scheduledEvents struct {
+	DocumentIncarnation int              `json:"DocumentIncarnation"`
+	Events              []scheduledEvent `json:"Events"`
+}
+
+type scheduledEvent struct {
+	EventId      string   `json:"EventId"`
+	EventType    string   `json:"EventType"`
+	ResourceType string   `json:"ResourceType"`
+	Resources    []string `json:"Resources"`
+	EventStatus  string   `json:"EventStatus"`
+	NotBefore    string   `json:"NotBefore"`
+}
+
+// getScheduledEvents queries the scheduled events of the virtual machine the agent runs on.
+func getScheduledEvents(ctx context.Context, client *http.Client, url string) (*scheduledEvents, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Metadata", "true")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	events := &scheduledEvents{}
+	if err := json.NewDecoder(response.Body).Decode(events); err != nil {
+		return nil, fmt.Errorf("could not decode scheduled events: %v", err)
+	}
+	return events, nil
+}
+
+// affects reports whether the event concerns the node. The resources of an event are the names
+// of the affected virtual machines. For scale set instances that is e.g. aks-spot-12345-vmss_10,
+// while the node is named after the computer name aks-spot-12345-vmss00000a.
+func (e scheduledEvent) affects(nodeName string) bool {
+	return slices.ContainsFunc(e.Resources, func(resource string) bool {
+		return strings.EqualFold(resource, nodeName) || strings.EqualFold(resource, scaleSetInstanceName(nodeName))
+	})
+}
+
+// scaleSetInstanceName converts the computer name of a scale set instance, which ends with the
+// instance id as 6 digit base 36 number, to the name of the instance.
+func scaleSetInstanceName(computerName string) string {
+	index := strings.LastIndex(computerName, "vmss")
+	if index < 0 || len(computerName) != index+len("vmss")+6 {
+		return computerName
+	}
+	id, err := strconv.ParseInt(computerName[index+len("vmss"):], 36, 64)
+	if err != nil {
+		return computerName
+	}
+	return fmt.Sprintf("%svmss_%d", computerName[:index], id)
+}
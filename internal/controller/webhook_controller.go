@@ -15,6 +15,7 @@ import (
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
 	myutil "github.com/stein-solutions/aks-spot-instance-tolerator/internal/util"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -89,7 +90,9 @@ func (wc *WebhookController) updateWebhook(oldCert []byte, newCert []byte) error
 		slog.Error(fmt.Sprintf("Error getting webhook configuration. %s", err))
 		return err
 	}
-	webhookConfiguration.Webhooks[0].ClientConfig.CABundle = caBundle
+	for i := range webhookConfiguration.Webhooks {
+		webhookConfiguration.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
 	_, err = wc.k8sClient.Clientset().AdmissionregistrationV1().MutatingWebhookConfigurations().
 		Update(context.TODO(), webhookConfiguration, metav1.UpdateOptions{})
 	if err != nil {
@@ -98,6 +101,33 @@ func (wc *WebhookController) updateWebhook(oldCert []byte, newCert []byte) error
 	}
 
 	slog.Info("Webhook CR updated successfully")
+	return wc.updateValidatingWebhook(caBundle)
+}
+
+// updateValidatingWebhook updates the CA bundle of the validating webhook configuration of the
+// same name. The validating webhook is optional, a missing configuration is not an error.
+func (wc *WebhookController) updateValidatingWebhook(caBundle []byte) error {
+	webhookConfiguration, err := wc.k8sClient.Clientset().AdmissionregistrationV1().ValidatingWebhookConfigurations().
+		Get(context.TODO(), wc.config.WebhookName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		slog.Debug("No validating webhook configuration found. Skipping")
+		return nil
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error getting validating webhook configuration. %s", err))
+		return err
+	}
+	for i := range webhookConfiguration.Webhooks {
+		webhookConfiguration.Webhooks[i].ClientConfig.CABundle = caBundle
+	}
+	_, err = wc.k8sClient.Clientset().AdmissionregistrationV1().ValidatingWebhookConfigurations().
+		Update(context.TODO(), webhookConfiguration, metav1.UpdateOptions{})
+	if err != nil {
+		slog.Error(fmt.Sprintf("Error updating validating webhook configuration. %s", err))
+		return err
+	}
+
+	slog.Info("Validating webhook CR updated successfully")
 	return nil
 }
 
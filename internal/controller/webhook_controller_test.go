@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config" // Add this import
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/util"
 	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
 	v1 "k8s.io/api/core/v1"
@@ -34,6 +35,10 @@ func (m *MockK8sClient) Clientset() kubernetes.Interface {
 	return m._clientset
 }
 
+func (m *MockK8sClient) SpotPolicies() k8sClient.SpotPolicyInterface {
+	return nil
+}
+
 func TestUpdateSecret_ShouldRenewCert(t *testing.T) {
 	config := config.NewConfig()
 
@@ -165,6 +170,57 @@ func TestUpdateSecre_HappyCase(t *testing.T) {
 	}
 }
 
+func TestUpdateWebhook_UpdatesAllWebhooks(t *testing.T) {
+	config := config.NewConfig()
+
+	mutatingConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: config.WebhookName},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "pods"}, {Name: "workloads"}},
+	}
+	validatingConfig := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: config.WebhookName},
+		Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "pods"}},
+	}
+	k8sClient := NewMockK8sClient(mutatingConfig, validatingConfig)
+	controller := NewWebhookController(k8sClient, config, util.NewSecretWatcher(""))
+
+	if err := controller.updateWebhook([]byte("old-cert"), []byte("new-cert")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	mutating, err := k8sClient.Clientset().AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.TODO(), config.WebhookName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	for _, webhook := range mutating.Webhooks {
+		if string(webhook.ClientConfig.CABundle) != "old-certnew-cert" {
+			t.Fatalf("expected CA bundle of webhook %s to be updated, got %q", webhook.Name, webhook.ClientConfig.CABundle)
+		}
+	}
+
+	validating, err := k8sClient.Clientset().AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.TODO(), config.WebhookName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if string(validating.Webhooks[0].ClientConfig.CABundle) != "old-certnew-cert" {
+		t.Fatalf("expected CA bundle of validating webhook to be updated, got %q", validating.Webhooks[0].ClientConfig.CABundle)
+	}
+}
+
+func TestUpdateWebhook_WithoutValidatingWebhook(t *testing.T) {
+	config := config.NewConfig()
+
+	mutatingConfig := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: config.WebhookName},
+		Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "pods"}},
+	}
+	controller := NewWebhookController(NewMockK8sClient(mutatingConfig), config, util.NewSecretWatcher(""))
+
+	if err := controller.updateWebhook(nil, []byte("new-cert")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
 func validateCertificates(tlsCertPEM, tlsKeyPEM, caCertPEM []byte, domains []string) error {
 	// TLS-Zertifikat parsen
 	tlsCertBlock, _ := pem.Decode(tlsCertPEM)
@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// patchAnnotations sets the annotations of the workload. Annotations with a nil value are
+// removed.
+func patchAnnotations(ctx context.Context, clientset kubernetes.Interface, workload k8sClient.Owner, annotations map[string]*string) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": annotations,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	namespace, name := workload.Object.GetNamespace(), workload.Object.GetName()
+	options := metav1.PatchOptions{}
+	switch workload.Object.(type) {
+	case *appsv1.Deployment:
+		_, err = clientset.AppsV1().Deployments(namespace).Patch(ctx, name, types.MergePatchType, patch, options)
+	case *appsv1.StatefulSet:
+		_, err = clientset.AppsV1().StatefulSets(namespace).Patch(ctx, name, types.MergePatchType, patch, options)
+	case *appsv1.DaemonSet:
+		_, err = clientset.AppsV1().DaemonSets(namespace).Patch(ctx, name, types.MergePatchType, patch, options)
+	case *appsv1.ReplicaSet:
+		_, err = clientset.AppsV1().ReplicaSets(namespace).Patch(ctx, name, types.MergePatchType, patch, options)
+	case *batchv1.Job:
+		_, err = clientset.BatchV1().Jobs(namespace).Patch(ctx, name, types.MergePatchType, patch, options)
+	case *batchv1.CronJob:
+		_, err = clientset.BatchV1().CronJobs(namespace).Patch(ctx, name, types.MergePatchType, patch, options)
+	default:
+		return fmt.Errorf("unsupported workload kind %s", workload.Kind)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// auditOnly reports whether pods in the namespace are only audited. The audit mode of the
+// namespace wins over the configured audit mode.
+func (s *Server) auditOnly(namespace string) bool {
+	if ns := s.getNamespace(namespace); ns != nil {
+		audit, found, err := policy.AuditFromObject(ns)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Ignoring audit mode of namespace %s. %v", namespace, err))
+		} else if found {
+			return audit
+		}
+	}
+	return s.config.AuditMode
+}
+
+// audit reports the mutation that would have been applied through a warning to the client
+// and annotations of the audit event instead of patching the object of the given kind.
+func audit(response *admissionv1.AdmissionResponse, kind string, result mutation, patch []byte) {
+	operations := make([]string, 0, len(result.patches))
+	for _, operation := range result.patches {
+		operations = append(operations, fmt.Sprintf("%s %s", operation.Op, operation.Path))
+	}
+
+	response.Warnings = append(response.Warnings, fmt.Sprintf("spot-tolerator audit mode: the %s would be mutated with mode %s (%s): %s",
+		strings.ToLower(kind), result.decision.Mode, result.decision.Reason, strings.Join(operations, ", ")))
+
+	if response.AuditAnnotations == nil {
+		response.AuditAnnotations = map[string]string{}
+	}
+	response.AuditAnnotations["audit-mode"] = "true"
+	response.AuditAnnotations["mode"] = string(result.decision.Mode)
+	response.AuditAnnotations["reason"] = result.decision.Reason
+	if result.decision.Rule != "" {
+		response.AuditAnnotations["rule"] = result.decision.Rule
+	}
+	response.AuditAnnotations["patch"] = string(patch)
+}
@@ -0,0 +1,68 @@
+package http
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+)
+
+var _ = Describe("audit mode", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		server = NewServer(cfg, newTestCache(
+			namespaceWithMode("audited", map[string]string{policy.AuditAnnotation: "true"}, nil),
+			namespaceWithMode("enforced", map[string]string{policy.AuditAnnotation: "false"}, nil),
+			namespaceWithMode("plain", nil, nil),
+		))
+	})
+
+	It("should report the mutation instead of patching the pod", func() {
+		response := reviewPodIn(server, "audited", `{"metadata": {"name": "test-pod"}, "spec": {}}`)
+
+		Expect(response.Response.Allowed).To(BeTrue())
+		Expect(response.Response.Patch).To(BeEmpty())
+		Expect(response.Response.PatchType).To(BeNil())
+		Expect(response.Response.Warnings).To(ConsistOf(
+			"spot-tolerator audit mode: the pod would be mutated with mode tolerate (default mode): add /spec/tolerations, add /metadata/annotations, add /metadata/labels"))
+		Expect(response.Response.AuditAnnotations).To(HaveKeyWithValue("mode", "tolerate"))
+		Expect(response.Response.AuditAnnotations).To(HaveKeyWithValue("reason", "default mode"))
+		Expect(response.Response.AuditAnnotations["patch"]).To(MatchJSON(`[{
+			"op": "add",
+			"path": "/spec/tolerations",
+			"value": [{"key": "kubernetes.azure.com/scalesetpriority", "operator": "Equal", "value": "spot", "effect": "NoSchedule"}]
+		}, {
+			"op": "add",
+			"path": "/metadata/annotations",
+			"value": {"spot-tolerator.stein.solutions/decision": "{\"mode\":\"tolerate\",\"reason\":\"default mode\"}"}
+		}, {
+			"op": "add",
+			"path": "/metadata/labels",
+			"value": {"spot-tolerator.stein.solutions/mutated": "true"}
+		}]`))
+	})
+
+	It("should audit all namespaces if configured", func() {
+		cfg.AuditMode = true
+
+		Expect(reviewPodIn(server, "plain", `{"metadata": {"name": "test-pod"}, "spec": {}}`).Response.Patch).To(BeEmpty())
+	})
+
+	It("should let namespaces opt out of the configured audit mode", func() {
+		cfg.AuditMode = true
+
+		Expect(reviewPodIn(server, "enforced", `{"metadata": {"name": "test-pod"}, "spec": {}}`).Response.Patch).NotTo(BeEmpty())
+	})
+
+	It("should not report pods that would not be mutated", func() {
+		response := reviewPodIn(server, "audited", `{"metadata": {"name": "test-pod", "annotations": {"spot-tolerator.stein.solutions/mode": "skip"}}, "spec": {}}`)
+
+		Expect(response.Response.Warnings).To(BeEmpty())
+		Expect(response.Response.AuditAnnotations).To(BeEmpty())
+	})
+})
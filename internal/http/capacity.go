@@ -0,0 +1,42 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+)
+
+// applySpotCapacity limits the decision to the configured mode while no spot node can take new
+// pods, so that pods requiring spot nodes do not stay pending. The client is warned about the
+// downgrade. Modes declared by the pod itself and pods of spot-only namespaces are kept, the
+// latter wait for spot nodes.
+func (s *Server) applySpotCapacity(namespace string, decision policy.Decision) policy.Decision {
+	limit := s.config.NoSpotCapacityMode
+	if limit == "" || s.cache == nil || decision.Source == policy.SourcePod {
+		return decision
+	}
+	if s.getPlacement(namespace) == policy.PlacementSpotOnly {
+		return decision
+	}
+	mode := decision.Mode.Cap(limit)
+	if mode == decision.Mode {
+		return decision
+	}
+
+	ready, err := s.cache.ReadySpotNodes()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not count ready spot nodes. %v", err))
+		return decision
+	}
+	if ready > 0 {
+		return decision
+	}
+
+	warning := fmt.Sprintf("spot-tolerator: no ready spot nodes, mode %s is downgraded to %s", decision.Mode, mode)
+	slog.Warn(fmt.Sprintf("No ready spot nodes. Downgrading mode %s to %s (%s)", decision.Mode, mode, decision.Reason))
+	decision.Mode = mode
+	decision.Reason = fmt.Sprintf("%s, downgraded without ready spot nodes", decision.Reason)
+	decision.Warnings = append(decision.Warnings, warning)
+	return decision
+}
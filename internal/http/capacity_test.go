@@ -0,0 +1,81 @@
+package http
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func readyNode(name string, spot bool) *corev1.Node {
+	node := node(name, spot)
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	return node
+}
+
+var _ = Describe("spot capacity", func() {
+	var cfg *config.Config
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		cfg.DefaultMode = policy.ModeRequire
+		cfg.NoSpotCapacityMode = policy.ModeTolerate
+	})
+
+	serverWith := func(objects ...runtime.Object) *Server {
+		return NewServer(cfg, newTestCache(append(objects, namespaceWithMode("plain", nil, nil))...))
+	}
+
+	It("should downgrade to the configured mode without ready spot nodes", func() {
+		cordoned := readyNode("spot-2", true)
+		cordoned.Spec.Unschedulable = true
+		server := serverWith(readyNode("system-1", false), node("spot-1", true), cordoned)
+
+		decision := decideFor(server, "plain", &corev1.Pod{})
+		Expect(decision.Mode).To(Equal(policy.ModeTolerate))
+		Expect(decision.Reason).To(Equal("default mode, downgraded without ready spot nodes"))
+		Expect(decision.Warnings).To(ConsistOf("spot-tolerator: no ready spot nodes, mode require is downgraded to tolerate"))
+	})
+
+	It("should keep the mode with ready spot nodes", func() {
+		server := serverWith(readyNode("spot-1", true))
+
+		decision := decideFor(server, "plain", &corev1.Pod{})
+		Expect(decision.Mode).To(Equal(policy.ModeRequire))
+		Expect(decision.Warnings).To(BeEmpty())
+	})
+
+	It("should return the warning with the admission response", func() {
+		cfg.NoSpotCapacityMode = policy.ModeSkip
+		server := serverWith()
+
+		response := reviewPodIn(server, "plain", `{"metadata": {"name": "test-pod"}, "spec": {}}`)
+		Expect(response.Response.Patch).To(BeEmpty())
+		Expect(response.Response.Warnings).To(ConsistOf("spot-tolerator: no ready spot nodes, mode require is downgraded to skip"))
+	})
+
+	It("should keep the mode declared by the pod", func() {
+		server := serverWith()
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{policy.ModeAnnotation: "require"}}}
+
+		Expect(decideFor(server, "plain", pod).Mode).To(Equal(policy.ModeRequire))
+	})
+
+	It("should not check the capacity if not configured", func() {
+		cfg.NoSpotCapacityMode = ""
+		server := serverWith()
+
+		Expect(decideFor(server, "plain", &corev1.Pod{}).Mode).To(Equal(policy.ModeRequire))
+	})
+
+	It("should leave templates to the admission of their pods", func() {
+		server := serverWith()
+		result := server.mutateTemplate(workloadRequestFor("apps", "Deployment", `{"metadata": {"name": "web"}, "spec": {"template": {"spec": {}}}}`))
+
+		Expect(result.patches).To(BeEmpty())
+		Expect(result.decision.Mode).To(Equal(policy.ModeSkip))
+	})
+})
@@ -0,0 +1,173 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	toolscache "k8s.io/client-go/tools/cache"
+)
+
+// decide determines how the pod is mutated in the following order:
+//  1. The declared mode: the configured default, the default for the workload kind, the mode
+//     of the namespace, the first matching SpotPolicy rule, the mode of the owners, of which the
+//     top-level owner (e.g. the Deployment or CronJob) wins, and finally the mode of the pod.
+//     A matching rule also adds its tolerations and node affinity.
+//  2. Modes not declared by the pod are split by the spot ratio of the workload.
+//  3. The mode is downgraded for risky pods, for workloads that need to keep replicas on
+//     on-demand nodes, for workloads falling back to on-demand nodes and while there are no
+//     ready spot nodes.
+//  4. The placement of the namespace is enforced.
+func (s *Server) decide(namespace string, pod *corev1.Pod, owners []k8sClient.Owner, userInfo authenticationv1.UserInfo) policy.Decision {
+	decision := s.declaredDecision(namespace, pod, owners, userInfo)
+	decision = s.applySpotRatio(owners, decision)
+	decision = s.applyRisks(pod, decision)
+	decision = s.applyReplicaProtection(owners, decision)
+	decision = s.applyFallback(owners, decision)
+	decision = s.applySpotCapacity(namespace, decision)
+	return s.applyPlacement(namespace, pod, owners, decision)
+}
+
+// declaredDecision returns the mode declared for the pod by the configuration, its namespace,
+// the SpotPolicy rules and the annotations of its owners and the pod itself.
+func (s *Server) declaredDecision(namespace string, pod *corev1.Pod, owners []k8sClient.Owner, userInfo authenticationv1.UserInfo) policy.Decision {
+	decision := policy.Decision{Mode: s.config.DefaultMode, Source: policy.SourceDefault, Reason: "default mode"}
+
+	kind := workloadKind(pod, owners)
+	if mode, exists := s.config.KindDefaults[kind]; exists {
+		decision = policy.Decision{Mode: mode, Source: policy.SourceKind, Reason: fmt.Sprintf("default for %s", kind)}
+	}
+
+	ns := s.getNamespace(namespace)
+	if ns != nil {
+		mode, found, err := policy.ModeFromObject(ns)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Ignoring mode of namespace %s. %v", namespace, err))
+		} else if found {
+			decision = policy.Decision{Mode: mode, Source: policy.SourceNamespace, Reason: fmt.Sprintf("namespace %s", namespace)}
+		}
+	}
+
+	input := policy.RuleInput{Namespace: ns, Pod: pod, OwnerKind: kind, UserInfo: userInfo}
+	if match := s.rules.MatchRule(s.getSpotPolicies(), input); match != nil {
+		decision = policy.Decision{
+			Mode:         match.Mode,
+			Source:       policy.SourceRule,
+			Reason:       fmt.Sprintf("rule %s", match.Name),
+			Rule:         match.Name,
+			Tolerations:  match.Rule.Tolerations,
+			NodeAffinity: match.Rule.NodeAffinity,
+		}
+	}
+
+	for _, owner := range owners {
+		mode, found, err := policy.ModeFromObject(owner.Object)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Ignoring mode of %s %s/%s. %v", owner.Kind, namespace, owner.Object.GetName(), err))
+		} else if found {
+			decision = decision.Override(mode, policy.SourceOwner, fmt.Sprintf("%s %s", owner.Kind, owner.Object.GetName()))
+		}
+	}
+
+	mode, found, err := policy.ModeFromObject(pod)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Ignoring mode of pod %s/%s. %v", namespace, pod.Name, err))
+	} else if found {
+		decision = decision.Override(mode, policy.SourcePod, "pod annotation")
+	}
+	return decision
+}
+
+// applyRisks downgrades the decision for pods that are risky to run on spot nodes, unless
+// the pod itself asks for the mode.
+func (s *Server) applyRisks(pod *corev1.Pod, decision policy.Decision) policy.Decision {
+	if decision.Source == policy.SourcePod {
+		return decision
+	}
+
+	for _, risk := range policy.ClassifyRisks(pod) {
+		limit, exists := s.config.RiskModes[risk]
+		if !exists {
+			continue
+		}
+		if mode := decision.Mode.Cap(limit); mode != decision.Mode {
+			decision.Mode = mode
+			decision.Reason = fmt.Sprintf("%s, downgraded for %s", decision.Reason, risk)
+		}
+	}
+	return decision
+}
+
+func (s *Server) getNamespace(name string) *corev1.Namespace {
+	if s.cache == nil || name == "" {
+		return nil
+	}
+
+	ns, err := s.cache.Namespaces().Get(name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			slog.Error(fmt.Sprintf("Could not get namespace %s from cache. %v", name, err))
+		}
+		return nil
+	}
+	return ns
+}
+
+// loadSpotPolicy compiles the match expressions of a new or changed SpotPolicy, so that
+// invalid rules are reported when the policy is loaded rather than on admission.
+func (s *Server) loadSpotPolicy(obj interface{}) {
+	spotPolicy, ok := obj.(*v1alpha1.SpotPolicy)
+	if !ok {
+		return
+	}
+	if err := s.rules.Load(spotPolicy); err != nil {
+		slog.Error(fmt.Sprintf("SpotPolicy %s contains invalid rules. %v", spotPolicy.Name, err))
+		return
+	}
+	slog.Info(fmt.Sprintf("Loaded SpotPolicy %s", spotPolicy.Name))
+}
+
+func (s *Server) forgetSpotPolicy(obj interface{}) {
+	if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	if spotPolicy, ok := obj.(*v1alpha1.SpotPolicy); ok {
+		s.rules.Forget(spotPolicy.Name)
+	}
+}
+
+func (s *Server) getSpotPolicies() []*v1alpha1.SpotPolicy {
+	if s.cache == nil {
+		return nil
+	}
+	return s.cache.SpotPolicies()
+}
+
+func (s *Server) getOwners(pod *corev1.Pod) []k8sClient.Owner {
+	if s.cache == nil {
+		return nil
+	}
+	return s.cache.Owners(pod)
+}
+
+// workloadKind returns the kind of the top-level owner of the pod or Pod for pods without
+// controller. Owners that could not be resolved are identified by their reference.
+func workloadKind(pod *corev1.Pod, owners []k8sClient.Owner) string {
+	var top metav1.Object = pod
+	kind := "Pod"
+	if len(owners) > 0 {
+		top = owners[len(owners)-1].Object
+		kind = owners[len(owners)-1].Kind
+	}
+
+	if ref := metav1.GetControllerOf(top); ref != nil {
+		return ref.Kind
+	}
+	return kind
+}
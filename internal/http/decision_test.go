@@ -0,0 +1,269 @@
+package http
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type mockK8sClient struct {
+	clientset    kubernetes.Interface
+	spotPolicies k8sClient.SpotPolicyInterface
+}
+
+func (m *mockK8sClient) Clientset() kubernetes.Interface {
+	return m.clientset
+}
+
+func (m *mockK8sClient) SpotPolicies() k8sClient.SpotPolicyInterface {
+	return m.spotPolicies
+}
+
+// newTestCache starts a cache serving the objects. SpotPolicies are served as unstructured
+// objects by a fake dynamic client, everything else by a fake clientset.
+func newTestCache(objects ...runtime.Object) *k8sClient.Cache {
+	policies, others := []runtime.Object{}, []runtime.Object{}
+	for _, obj := range objects {
+		if spotPolicy, ok := obj.(*v1alpha1.SpotPolicy); ok {
+			content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(spotPolicy)
+			Expect(err).NotTo(HaveOccurred())
+			unstructuredPolicy := &unstructured.Unstructured{Object: content}
+			unstructuredPolicy.SetGroupVersionKind(v1alpha1.SchemeGroupVersion.WithKind("SpotPolicy"))
+			policies = append(policies, unstructuredPolicy)
+		} else {
+			others = append(others, obj)
+		}
+	}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.SpotPolicyResource: "SpotPolicyList"}, policies...)
+
+	clientset := fake.NewSimpleClientset(others...)
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: v1alpha1.SchemeGroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: v1alpha1.SpotPolicyResource.Resource, Kind: "SpotPolicy"}},
+	}}
+	cache := k8sClient.NewCache(&mockK8sClient{
+		clientset:    clientset,
+		spotPolicies: k8sClient.NewSpotPolicyClient(dynamicClient),
+	}, 0)
+	Expect(cache.Start(make(chan struct{}))).To(Succeed())
+	return cache
+}
+
+// decideFor decides the pod like on admission by an anonymous user.
+func decideFor(server *Server, namespace string, pod *corev1.Pod) policy.Decision {
+	return server.decide(namespace, pod, server.getOwners(pod), authenticationv1.UserInfo{})
+}
+
+func controllerRef(apiVersion, kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(name), Controller: &controller}}
+}
+
+func namespaceWithMode(name string, labels map[string]string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+}
+
+var _ = Describe("decide", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		server = NewServer(cfg, newTestCache(
+			namespaceWithMode("labeled", map[string]string{policy.ModeAnnotation: "off"}, nil),
+			namespaceWithMode("annotated", map[string]string{policy.ModeAnnotation: "off"}, map[string]string{policy.ModeAnnotation: "require-spot"}),
+			namespaceWithMode("invalid", map[string]string{policy.ModeAnnotation: "sometimes"}, nil),
+			namespaceWithMode("plain", nil, nil),
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "labeled", UID: "web",
+				Annotations: map[string]string{policy.ModeAnnotation: "prefer"}}},
+			&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-123", Namespace: "labeled", UID: "web-123",
+				Annotations:     map[string]string{policy.ModeAnnotation: "require"},
+				OwnerReferences: controllerRef("apps/v1", "Deployment", "web")}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "plain", UID: "api"}},
+			&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "api-123", Namespace: "plain", UID: "api-123",
+				OwnerReferences: controllerRef("apps/v1", "Deployment", "api")}},
+		))
+	})
+
+	It("should use the default mode for namespaces without mode", func() {
+		cfg.DefaultMode = policy.ModePrefer
+		Expect(decideFor(server, "plain", &corev1.Pod{}).Mode).To(Equal(policy.ModePrefer))
+	})
+
+	It("should use the default mode for unknown namespaces", func() {
+		Expect(decideFor(server, "unknown", &corev1.Pod{}).Mode).To(Equal(policy.ModeTolerate))
+	})
+
+	It("should use the mode of the namespace label", func() {
+		Expect(decideFor(server, "labeled", &corev1.Pod{}).Mode).To(Equal(policy.ModeSkip))
+	})
+
+	It("should prefer the namespace annotation over the label", func() {
+		Expect(decideFor(server, "annotated", &corev1.Pod{}).Mode).To(Equal(policy.ModeRequire))
+	})
+
+	It("should ignore invalid namespace modes", func() {
+		Expect(decideFor(server, "invalid", &corev1.Pod{}).Mode).To(Equal(policy.ModeTolerate))
+	})
+
+	It("should let the pod override the namespace", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{policy.ModeAnnotation: "inject"}}}
+		decision := decideFor(server, "labeled", pod)
+
+		Expect(decision.Mode).To(Equal(policy.ModeTolerate))
+		Expect(decision.Reason).To(Equal("pod annotation"))
+	})
+
+	It("should use the mode of the top-level owner", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "labeled", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", "web-123")}}
+		decision := decideFor(server, "labeled", pod)
+
+		Expect(decision.Mode).To(Equal(policy.ModePrefer))
+		Expect(decision.Reason).To(Equal("Deployment web"))
+	})
+
+	It("should let the pod override its owners", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "labeled", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", "web-123"),
+			Annotations: map[string]string{policy.ModeAnnotation: "skip"}}}
+
+		Expect(decideFor(server, "labeled", pod).Mode).To(Equal(policy.ModeSkip))
+	})
+
+	Context("kind defaults", func() {
+		BeforeEach(func() {
+			cfg.KindDefaults = map[string]policy.Mode{"Pod": policy.ModeSkip, "Deployment": policy.ModeRequire, "Job": policy.ModeTolerate}
+		})
+
+		It("should use the default of bare pods", func() {
+			decision := decideFor(server, "plain", &corev1.Pod{})
+
+			Expect(decision.Mode).To(Equal(policy.ModeSkip))
+			Expect(decision.Reason).To(Equal("default for Pod"))
+		})
+
+		It("should use the default of the top-level owner", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", "api-123")}}
+			cfg.DefaultMode = policy.ModeSkip
+
+			Expect(decideFor(server, "plain", pod).Mode).To(Equal(policy.ModeRequire))
+		})
+
+		It("should use the default of unresolved owners", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("batch/v1", "Job", "unknown")}}
+			cfg.DefaultMode = policy.ModeSkip
+
+			Expect(decideFor(server, "plain", pod).Mode).To(Equal(policy.ModeTolerate))
+		})
+
+		It("should let the namespace override the kind default", func() {
+			Expect(decideFor(server, "annotated", &corev1.Pod{}).Mode).To(Equal(policy.ModeRequire))
+		})
+	})
+
+	Context("spot policies", func() {
+		batchToleration := corev1.Toleration{Key: "workload-class", Operator: corev1.TolerationOpEqual, Value: "batch", Effect: corev1.TaintEffectNoSchedule}
+
+		BeforeEach(func() {
+			server = NewServer(cfg, newTestCache(
+				namespaceWithMode("labeled", map[string]string{policy.ModeAnnotation: "off"}, nil),
+				namespaceWithMode("ci", map[string]string{"team": "ci"}, nil),
+				&v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: "platform"}, Spec: v1alpha1.SpotPolicySpec{Rules: []v1alpha1.SpotPolicyRule{
+					{Name: "ci", NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "ci"}},
+						Action: v1alpha1.ActionRequire, Tolerations: []corev1.Toleration{batchToleration}},
+					{Name: "critical", PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "critical"}}, Action: v1alpha1.ActionSkip},
+				}}},
+			))
+		})
+
+		It("should use the action of the matching rule", func() {
+			decision := decideFor(server, "ci", &corev1.Pod{})
+
+			Expect(decision.Mode).To(Equal(policy.ModeRequire))
+			Expect(decision.Source).To(Equal(policy.SourceRule))
+			Expect(decision.Rule).To(Equal("platform/ci"))
+			Expect(decision.Tolerations).To(Equal([]corev1.Toleration{batchToleration}))
+		})
+
+		It("should override the namespace mode", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"tier": "critical"}}}
+			cfg.DefaultMode = policy.ModePrefer
+
+			Expect(decideFor(server, "labeled", pod).Rule).To(Equal("platform/critical"))
+			Expect(decideFor(server, "labeled", &corev1.Pod{}).Source).To(Equal(policy.SourceNamespace))
+		})
+
+		It("should keep the tolerations of the rule if the pod overrides the mode", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{policy.ModeAnnotation: "prefer"}}}
+			decision := decideFor(server, "ci", pod)
+
+			Expect(decision.Mode).To(Equal(policy.ModePrefer))
+			Expect(decision.Source).To(Equal(policy.SourcePod))
+			Expect(decision.Tolerations).To(Equal([]corev1.Toleration{batchToleration}))
+		})
+	})
+
+	Context("risky pods", func() {
+		var pod *corev1.Pod
+
+		BeforeEach(func() {
+			cfg.RiskModes = map[policy.Risk]policy.Mode{policy.RiskPersistentVolumeClaim: policy.ModeTolerate, policy.RiskHostNetwork: policy.ModeSkip}
+			pod = &corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+			}}}
+		})
+
+		It("should downgrade the mode", func() {
+			decision := decideFor(server, "annotated", pod)
+
+			Expect(decision.Mode).To(Equal(policy.ModeTolerate))
+			Expect(decision.Reason).To(Equal("namespace annotated, downgraded for persistentVolumeClaim"))
+		})
+
+		It("should apply the strictest limit", func() {
+			pod.Spec.HostNetwork = true
+
+			Expect(decideFor(server, "annotated", pod).Mode).To(Equal(policy.ModeSkip))
+		})
+
+		It("should not upgrade weaker modes", func() {
+			Expect(decideFor(server, "labeled", pod).Mode).To(Equal(policy.ModeSkip))
+		})
+
+		It("should respect the mode declared by the pod", func() {
+			pod.Annotations = map[string]string{policy.ModeAnnotation: "require"}
+
+			Expect(decideFor(server, "plain", pod).Mode).To(Equal(policy.ModeRequire))
+		})
+	})
+
+	It("should not patch pods in namespaces that are turned off", func() {
+		response := reviewPodIn(server, "labeled", `{"metadata": {"name": "test-pod"}}`)
+
+		Expect(response.Response).NotTo(BeNil())
+		Expect(response.Response.Patch).To(BeNil())
+	})
+})
@@ -0,0 +1,34 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+)
+
+// applyFallback keeps new pods of workloads marked by the pending pod controller from requiring
+// spot nodes. They still prefer spot nodes, a required node affinity of a rule is left out.
+func (s *Server) applyFallback(owners []k8sClient.Owner, decision policy.Decision) policy.Decision {
+	requiresAffinity := decision.NodeAffinity != nil && decision.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil
+	if decision.Mode != policy.ModeRequire && !requiresAffinity {
+		return decision
+	}
+
+	for i := len(owners) - 1; i >= 0; i-- {
+		if _, found, _ := policy.FallbackFromObject(owners[i].Object); !found {
+			continue
+		}
+
+		if decision.Mode == policy.ModeRequire {
+			decision.Mode = policy.ModePrefer
+		}
+		if requiresAffinity {
+			decision.NodeAffinity = decision.NodeAffinity.DeepCopy()
+			decision.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = nil
+		}
+		decision.Reason = fmt.Sprintf("%s, %s %s falls back to on-demand nodes", decision.Reason, owners[i].Kind, owners[i].Object.GetName())
+		return decision
+	}
+	return decision
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("fallback to on-demand nodes", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		cfg.DefaultMode = policy.ModeRequire
+		objects := deploymentObjects("web", 3)
+		objects[0].(*appsv1.Deployment).Annotations = map[string]string{policy.FallbackAnnotation: "2024-10-02T12:00:00Z"}
+		objects = append(objects, deploymentObjects("api", 3)...)
+		server = NewServer(cfg, newTestCache(objects...))
+	})
+
+	pod := func(owner string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", owner+"-1")}}
+	}
+
+	It("should only prefer spot nodes for workloads falling back", func() {
+		decision := decideFor(server, "plain", pod("web"))
+
+		Expect(decision.Mode).To(Equal(policy.ModePrefer))
+		Expect(decision.Reason).To(Equal("default mode, Deployment web falls back to on-demand nodes"))
+		Expect(decideFor(server, "plain", pod("api")).Mode).To(Equal(policy.ModeRequire))
+	})
+
+	It("should leave out the required node affinity of a rule", func() {
+		decision := server.applyFallback(server.getOwners(pod("web")), policy.Decision{
+			Mode: policy.ModeTolerate,
+			NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"spot"}},
+				}}},
+			}},
+		})
+
+		Expect(decision.Mode).To(Equal(policy.ModeTolerate))
+		Expect(decision.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(BeNil())
+	})
+
+	It("should not touch other modes", func() {
+		decision := policy.Decision{Mode: policy.ModeTolerate, Source: policy.SourceRule, Rule: "batch/ci"}
+
+		Expect(server.applyFallback(server.getOwners(pod("web")), decision)).To(Equal(decision))
+	})
+})
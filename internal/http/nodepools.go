@@ -0,0 +1,54 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// tolerations returns the configured tolerations. With node pool discovery tolerations for
+// the taints of all discovered spot pools are added, so pods can land on new spot pools with
+// custom taints without reconfiguring the webhook. Every mutated pod gets these tolerations, so
+// only the taint keys allowed by the configuration are tolerated, keeping dedicated spot pools,
+// e.g. for GPUs, closed to other workloads.
+func (s *Server) tolerations() []corev1.Toleration {
+	tolerations := slices.Clone(s.config.Tolerations)
+	if !s.config.NodePoolDiscovery || s.cache == nil {
+		return tolerations
+	}
+
+	pools, err := s.cache.SpotNodePools()
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not discover spot node pools. %v", err))
+		return tolerations
+	}
+	for _, pool := range pools {
+		for _, taint := range pool.Taints {
+			if taint.Effect == corev1.TaintEffectPreferNoSchedule || !s.toleratesDiscovered(taint.Key) {
+				continue
+			}
+			if toleration := tolerationFor(taint); !isTolerated(tolerations, toleration) {
+				tolerations = append(tolerations, toleration)
+			}
+		}
+	}
+	return tolerations
+}
+
+// toleratesDiscovered reports whether a discovered taint with the key may be tolerated. Keys
+// on the ignore list are never tolerated, a non-empty list of taint keys is an allow list.
+func (s *Server) toleratesDiscovered(key string) bool {
+	if slices.Contains(s.config.NodePoolIgnoredTaintKeys, key) {
+		return false
+	}
+	return len(s.config.NodePoolTaintKeys) == 0 || slices.Contains(s.config.NodePoolTaintKeys, key)
+}
+
+func tolerationFor(taint corev1.Taint) corev1.Toleration {
+	if taint.Value == "" {
+		return corev1.Toleration{Key: taint.Key, Operator: corev1.TolerationOpExists, Effect: taint.Effect}
+	}
+	return corev1.Toleration{Key: taint.Key, Operator: corev1.TolerationOpEqual, Value: taint.Value, Effect: taint.Effect}
+}
@@ -0,0 +1,74 @@
+package http
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("node pool discovery", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	spotTaint := corev1.Taint{Key: config.SpotNodeLabelKey, Value: config.SpotNodeLabelValue, Effect: corev1.TaintEffectNoSchedule}
+	poolNode := func(name string, pool string, spot bool, taints ...corev1.Taint) *corev1.Node {
+		node := node(name, spot)
+		node.Labels[config.AgentPoolLabelKey] = pool
+		node.Spec.Taints = taints
+		return node
+	}
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		cfg.NodePoolDiscovery = true
+		server = NewServer(cfg, newTestCache(
+			poolNode("system-1", "system", false, corev1.Taint{Key: "CriticalAddonsOnly", Value: "true", Effect: corev1.TaintEffectNoSchedule}),
+			poolNode("spot-1", "spot", true, spotTaint),
+			poolNode("spotgpu-1", "spotgpu", true, spotTaint,
+				corev1.Taint{Key: "sku", Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+				corev1.Taint{Key: "dedicated", Effect: corev1.TaintEffectNoExecute},
+				corev1.Taint{Key: "soft", Value: "true", Effect: corev1.TaintEffectPreferNoSchedule}),
+		))
+	})
+
+	It("should tolerate the taints of all spot pools", func() {
+		Expect(server.tolerations()).To(Equal([]corev1.Toleration{
+			config.SpotToleration,
+			{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+			{Key: "sku", Operator: corev1.TolerationOpEqual, Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+		}))
+	})
+
+	It("should add the discovered tolerations to pods", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+		result := server.mutatePod(admissionRequestFor("default", pod))
+
+		Expect(result.patches[0].Path).To(Equal("/spec/tolerations"))
+		Expect(result.patches[0].Value).To(HaveLen(3))
+	})
+
+	It("should only tolerate the allowed taint keys", func() {
+		cfg.NodePoolIgnoredTaintKeys = []string{"sku"}
+		Expect(server.tolerations()).To(Equal([]corev1.Toleration{
+			config.SpotToleration,
+			{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoExecute},
+		}))
+
+		cfg.NodePoolIgnoredTaintKeys = nil
+		cfg.NodePoolTaintKeys = []string{"sku"}
+		Expect(server.tolerations()).To(Equal([]corev1.Toleration{
+			config.SpotToleration,
+			{Key: "sku", Operator: corev1.TolerationOpEqual, Value: "gpu", Effect: corev1.TaintEffectNoSchedule},
+		}))
+	})
+
+	It("should only use the configured tolerations without discovery", func() {
+		cfg.NodePoolDiscovery = false
+
+		Expect(server.tolerations()).To(Equal([]corev1.Toleration{config.SpotToleration}))
+	})
+})
@@ -0,0 +1,266 @@
+package http
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// The patch functions below return the operations needed to add to the pod spec without
+// touching what the pod already declares. They apply the operations to the given spec as
+// well, so that patches for the same pod can be built one after another.
+
+// tolerationPatches adds the wanted tolerations. Tolerations that are already covered by
+// an existing toleration are left out.
+func tolerationPatches(spec *corev1.PodSpec, wanted []corev1.Toleration) []patchOperation {
+	missing := []corev1.Toleration{}
+	for _, toleration := range wanted {
+		if !isTolerated(append(spec.Tolerations, missing...), toleration) {
+			missing = append(missing, toleration)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	existing := len(spec.Tolerations)
+	spec.Tolerations = append(spec.Tolerations, missing...)
+
+	if existing == 0 {
+		return []patchOperation{{Op: "add", Path: "/spec/tolerations", Value: missing}}
+	}
+
+	patches := make([]patchOperation, 0, len(missing))
+	for _, toleration := range missing {
+		patches = append(patches, patchOperation{Op: "add", Path: "/spec/tolerations/-", Value: toleration})
+	}
+	return patches
+}
+
+// isTolerated reports whether one of the existing tolerations already tolerates
+// everything the wanted toleration would.
+func isTolerated(existing []corev1.Toleration, wanted corev1.Toleration) bool {
+	for i := range existing {
+		if existing[i].MatchToleration(&wanted) {
+			return true
+		}
+		if wanted.Operator == corev1.TolerationOpExists {
+			if existing[i].Operator == corev1.TolerationOpExists &&
+				(existing[i].Key == "" || existing[i].Key == wanted.Key) &&
+				(existing[i].Effect == "" || existing[i].Effect == wanted.Effect) {
+				return true
+			}
+			continue
+		}
+		taint := corev1.Taint{Key: wanted.Key, Value: wanted.Value, Effect: wanted.Effect}
+		if wanted.Effect == "" {
+			// a toleration without effect needs to cover all effects
+			if existing[i].Effect != "" {
+				continue
+			}
+			taint.Effect = corev1.TaintEffectNoSchedule
+		}
+		if existing[i].ToleratesTaint(&taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// preferredAffinityPatches adds the preferred scheduling term to the node affinity.
+func preferredAffinityPatches(spec *corev1.PodSpec, term corev1.PreferredSchedulingTerm) []patchOperation {
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{term}},
+		}
+		return []patchOperation{{Op: "add", Path: "/spec/affinity", Value: spec.Affinity.DeepCopy()}}
+	}
+
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{term},
+		}
+		return []patchOperation{{Op: "add", Path: "/spec/affinity/nodeAffinity", Value: spec.Affinity.NodeAffinity.DeepCopy()}}
+	}
+
+	nodeAffinity := spec.Affinity.NodeAffinity
+	if len(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution) == 0 {
+		nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = []corev1.PreferredSchedulingTerm{term}
+		return []patchOperation{{Op: "add", Path: "/spec/affinity/nodeAffinity/preferredDuringSchedulingIgnoredDuringExecution",
+			Value: []corev1.PreferredSchedulingTerm{term}}}
+	}
+
+	for _, existing := range nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		if equality.Semantic.DeepEqual(existing.Preference, term.Preference) {
+			return nil
+		}
+	}
+
+	nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution = append(nodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution, term)
+	return []patchOperation{{Op: "add", Path: "/spec/affinity/nodeAffinity/preferredDuringSchedulingIgnoredDuringExecution/-", Value: term}}
+}
+
+// requiredAffinityPatches adds the requirements to every required node selector term of the
+// pod. Since the terms are ORed and the expressions of a term are ANDed, the pod can only be
+// scheduled on nodes matching the requirements afterwards. An error is returned and nothing
+// is added if the pod already pins itself to nodes that cannot match the requirements.
+func requiredAffinityPatches(spec *corev1.PodSpec, requirements []corev1.NodeSelectorRequirement) ([]patchOperation, error) {
+	if len(requirements) == 0 {
+		return nil, nil
+	}
+
+	for _, requirement := range requirements {
+		if value, exists := spec.NodeSelector[requirement.Key]; exists && requirement.Operator == corev1.NodeSelectorOpIn {
+			if !slices.Contains(requirement.Values, value) {
+				return nil, fmt.Errorf("node selector %s=%s conflicts with the required node affinity", requirement.Key, value)
+			}
+		}
+	}
+
+	required := &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: slices.Clone(requirements)}}}
+
+	if spec.Affinity == nil {
+		spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: required}}
+		return []patchOperation{{Op: "add", Path: "/spec/affinity", Value: spec.Affinity.DeepCopy()}}, nil
+	}
+
+	if spec.Affinity.NodeAffinity == nil {
+		spec.Affinity.NodeAffinity = &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: required}
+		return []patchOperation{{Op: "add", Path: "/spec/affinity/nodeAffinity", Value: spec.Affinity.NodeAffinity.DeepCopy()}}, nil
+	}
+
+	nodeAffinity := spec.Affinity.NodeAffinity
+	existing := nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if existing == nil || len(existing.NodeSelectorTerms) == 0 {
+		nodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = required
+		return []patchOperation{{Op: "add", Path: "/spec/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution", Value: required.DeepCopy()}}, nil
+	}
+
+	for i, term := range existing.NodeSelectorTerms {
+		for _, requirement := range requirements {
+			if conflictsWith(term.MatchExpressions, requirement) {
+				return nil, fmt.Errorf("node selector term %d conflicts with the required node affinity", i)
+			}
+		}
+	}
+
+	patches := []patchOperation{}
+	for i := range existing.NodeSelectorTerms {
+		term := &existing.NodeSelectorTerms[i]
+		path := fmt.Sprintf("/spec/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution/nodeSelectorTerms/%d/matchExpressions", i)
+		for _, requirement := range requirements {
+			if slices.ContainsFunc(term.MatchExpressions, func(r corev1.NodeSelectorRequirement) bool {
+				return equality.Semantic.DeepEqual(r, requirement)
+			}) {
+				continue
+			}
+
+			if len(term.MatchExpressions) == 0 {
+				patches = append(patches, patchOperation{Op: "add", Path: path, Value: []corev1.NodeSelectorRequirement{requirement}})
+			} else {
+				patches = append(patches, patchOperation{Op: "add", Path: path + "/-", Value: requirement})
+			}
+			term.MatchExpressions = append(term.MatchExpressions, requirement)
+		}
+	}
+	return patches, nil
+}
+
+// conflictsWith reports whether one of the expressions excludes all values the requirement
+// asks for.
+func conflictsWith(expressions []corev1.NodeSelectorRequirement, requirement corev1.NodeSelectorRequirement) bool {
+	if requirement.Operator != corev1.NodeSelectorOpIn {
+		return false
+	}
+	for _, expression := range expressions {
+		if expression.Key != requirement.Key {
+			continue
+		}
+		switch expression.Operator {
+		case corev1.NodeSelectorOpDoesNotExist:
+			return true
+		case corev1.NodeSelectorOpIn:
+			if !slices.ContainsFunc(requirement.Values, func(value string) bool { return slices.Contains(expression.Values, value) }) {
+				return true
+			}
+		case corev1.NodeSelectorOpNotIn:
+			if !slices.ContainsFunc(requirement.Values, func(value string) bool { return !slices.Contains(expression.Values, value) }) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// topologySpreadPatches adds the constraints for topology keys the pod does not spread over yet.
+func topologySpreadPatches(spec *corev1.PodSpec, wanted []corev1.TopologySpreadConstraint) []patchOperation {
+	missing := []corev1.TopologySpreadConstraint{}
+	for _, constraint := range wanted {
+		if !slices.ContainsFunc(spec.TopologySpreadConstraints, func(c corev1.TopologySpreadConstraint) bool { return c.TopologyKey == constraint.TopologyKey }) {
+			missing = append(missing, constraint)
+		}
+	}
+
+	if len(missing) == 0 {
+		return nil
+	}
+
+	existing := len(spec.TopologySpreadConstraints)
+	spec.TopologySpreadConstraints = append(spec.TopologySpreadConstraints, missing...)
+
+	if existing == 0 {
+		return []patchOperation{{Op: "add", Path: "/spec/topologySpreadConstraints", Value: missing}}
+	}
+
+	patches := make([]patchOperation, 0, len(missing))
+	for _, constraint := range missing {
+		patches = append(patches, patchOperation{Op: "add", Path: "/spec/topologySpreadConstraints/-", Value: constraint})
+	}
+	return patches
+}
+
+// metadataPatches sets the annotations and labels. Existing values of the same keys are replaced.
+func metadataPatches(meta *metav1.ObjectMeta, annotations map[string]string, labels map[string]string) []patchOperation {
+	patches := mapPatches("/metadata/annotations", meta.Annotations, annotations)
+	return append(patches, mapPatches("/metadata/labels", meta.Labels, labels)...)
+}
+
+func mapPatches(path string, existing map[string]string, wanted map[string]string) []patchOperation {
+	if len(wanted) == 0 {
+		return nil
+	}
+	if existing == nil {
+		return []patchOperation{{Op: "add", Path: path, Value: wanted}}
+	}
+
+	keys := make([]string, 0, len(wanted))
+	for key := range wanted {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	patches := make([]patchOperation, 0, len(wanted))
+	for _, key := range keys {
+		if value, exists := existing[key]; exists && value == wanted[key] {
+			continue
+		}
+		patches = append(patches, patchOperation{Op: "add", Path: path + "/" + escapeJSONPointer(key), Value: wanted[key]})
+	}
+	return patches
+}
+
+// escapeJSONPointer escapes a map key for use in a json patch path.
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
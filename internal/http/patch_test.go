@@ -0,0 +1,207 @@
+package http
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("tolerationPatches", func() {
+	It("should add the tolerations array if the pod has none", func() {
+		patches := tolerationPatches(&corev1.PodSpec{}, []corev1.Toleration{config.SpotToleration})
+
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal("/spec/tolerations"))
+		Expect(patches[0].Value).To(Equal([]corev1.Toleration{config.SpotToleration}))
+	})
+
+	It("should append missing tolerations only", func() {
+		other := corev1.Toleration{Key: "workload-class", Operator: corev1.TolerationOpEqual, Value: "batch", Effect: corev1.TaintEffectNoSchedule}
+		patches := tolerationPatches(&corev1.PodSpec{Tolerations: []corev1.Toleration{config.SpotToleration}}, []corev1.Toleration{config.SpotToleration, other})
+
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal("/spec/tolerations/-"))
+		Expect(patches[0].Value).To(Equal(other))
+	})
+
+	It("should treat a wildcard toleration as covering everything", func() {
+		patches := tolerationPatches(&corev1.PodSpec{Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}}}, []corev1.Toleration{config.SpotToleration})
+
+		Expect(patches).To(BeEmpty())
+	})
+
+	It("should not treat a toleration for a different effect as covering", func() {
+		existing := config.SpotToleration
+		existing.Effect = corev1.TaintEffectNoExecute
+		patches := tolerationPatches(&corev1.PodSpec{Tolerations: []corev1.Toleration{existing}}, []corev1.Toleration{config.SpotToleration})
+
+		Expect(patches).To(HaveLen(1))
+	})
+
+	It("should not add duplicates of the same wanted toleration", func() {
+		patches := tolerationPatches(&corev1.PodSpec{}, []corev1.Toleration{config.SpotToleration, config.SpotToleration})
+
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Value).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("preferredAffinityPatches", func() {
+	term := corev1.PreferredSchedulingTerm{
+		Weight: 100,
+		Preference: corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: config.SpotNodeLabelKey, Operator: corev1.NodeSelectorOpIn, Values: []string{"spot"}}},
+		},
+	}
+
+	It("should add the affinity if the pod has none", func() {
+		patches := preferredAffinityPatches(&corev1.PodSpec{}, term)
+
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal("/spec/affinity"))
+	})
+
+	It("should keep pod affinity and add the node affinity", func() {
+		patches := preferredAffinityPatches(&corev1.PodSpec{Affinity: &corev1.Affinity{PodAffinity: &corev1.PodAffinity{}}}, term)
+
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal("/spec/affinity/nodeAffinity"))
+	})
+
+	It("should keep required node affinity and add the preferred terms", func() {
+		affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{}}}
+		patches := preferredAffinityPatches(&corev1.PodSpec{Affinity: affinity}, term)
+
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal("/spec/affinity/nodeAffinity/preferredDuringSchedulingIgnoredDuringExecution"))
+		Expect(patches[0].Value).To(Equal([]corev1.PreferredSchedulingTerm{term}))
+	})
+
+	It("should append to existing preferred terms", func() {
+		other := corev1.PreferredSchedulingTerm{Weight: 10, Preference: corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"1"}}},
+		}}
+		affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{other}}}
+		patches := preferredAffinityPatches(&corev1.PodSpec{Affinity: affinity}, term)
+
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal("/spec/affinity/nodeAffinity/preferredDuringSchedulingIgnoredDuringExecution/-"))
+		Expect(patches[0].Value).To(Equal(term))
+	})
+
+	It("should not add the term twice", func() {
+		existing := term
+		existing.Weight = 1
+		affinity := &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{existing}}}
+
+		Expect(preferredAffinityPatches(&corev1.PodSpec{Affinity: affinity}, term)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("requiredAffinityPatches", func() {
+	requirement := corev1.NodeSelectorRequirement{Key: config.SpotNodeLabelKey, Operator: corev1.NodeSelectorOpIn, Values: []string{"spot"}}
+	zoneRequirement := corev1.NodeSelectorRequirement{Key: "topology.kubernetes.io/zone", Operator: corev1.NodeSelectorOpIn, Values: []string{"westeurope-1"}}
+	requiredPath := "/spec/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution"
+
+	It("should add the affinity if the pod has none", func() {
+		patches, err := requiredAffinityPatches(&corev1.PodSpec{}, []corev1.NodeSelectorRequirement{requirement})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal("/spec/affinity"))
+	})
+
+	It("should add the required node selector next to preferred terms", func() {
+		spec := &corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{{Weight: 1}},
+		}}}
+		patches, err := requiredAffinityPatches(spec, []corev1.NodeSelectorRequirement{requirement})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patches).To(HaveLen(1))
+		Expect(patches[0].Path).To(Equal(requiredPath))
+	})
+
+	It("should add the requirement to every existing term", func() {
+		spec := &corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement}},
+				{MatchFields: []corev1.NodeSelectorRequirement{{Key: "metadata.name", Operator: corev1.NodeSelectorOpIn, Values: []string{"node-1"}}}},
+				{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement, requirement}},
+			}},
+		}}}
+		patches, err := requiredAffinityPatches(spec, []corev1.NodeSelectorRequirement{requirement})
+
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patches).To(Equal([]patchOperation{
+			{Op: "add", Path: requiredPath + "/nodeSelectorTerms/0/matchExpressions/-", Value: requirement},
+			{Op: "add", Path: requiredPath + "/nodeSelectorTerms/1/matchExpressions", Value: []corev1.NodeSelectorRequirement{requirement}},
+		}))
+	})
+
+	It("should refuse pods pinned to on-demand nodes by node selector", func() {
+		_, err := requiredAffinityPatches(&corev1.PodSpec{NodeSelector: map[string]string{config.SpotNodeLabelKey: "regular"}}, []corev1.NodeSelectorRequirement{requirement})
+
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should refuse pods pinned to on-demand nodes by node affinity", func() {
+		for _, expression := range []corev1.NodeSelectorRequirement{
+			{Key: config.SpotNodeLabelKey, Operator: corev1.NodeSelectorOpDoesNotExist},
+			{Key: config.SpotNodeLabelKey, Operator: corev1.NodeSelectorOpNotIn, Values: []string{"spot"}},
+			{Key: config.SpotNodeLabelKey, Operator: corev1.NodeSelectorOpIn, Values: []string{"regular"}},
+		} {
+			spec := &corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{zoneRequirement}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{expression}},
+				}},
+			}}}
+			_, err := requiredAffinityPatches(spec, []corev1.NodeSelectorRequirement{requirement})
+
+			Expect(err).To(HaveOccurred(), string(expression.Operator))
+		}
+	})
+})
+
+var _ = Describe("topologySpreadPatches", func() {
+	zone := corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.ScheduleAnyway}
+	capacity := corev1.TopologySpreadConstraint{MaxSkew: 1, TopologyKey: config.SpotNodeLabelKey, WhenUnsatisfiable: corev1.ScheduleAnyway}
+
+	It("should add the constraints if the pod has none", func() {
+		patches := topologySpreadPatches(&corev1.PodSpec{}, []corev1.TopologySpreadConstraint{capacity, zone})
+
+		Expect(patches).To(Equal([]patchOperation{{Op: "add", Path: "/spec/topologySpreadConstraints", Value: []corev1.TopologySpreadConstraint{capacity, zone}}}))
+	})
+
+	It("should keep constraints of the pod for the same topology key", func() {
+		existing := zone
+		existing.MaxSkew = 2
+		patches := topologySpreadPatches(&corev1.PodSpec{TopologySpreadConstraints: []corev1.TopologySpreadConstraint{existing}}, []corev1.TopologySpreadConstraint{capacity, zone})
+
+		Expect(patches).To(Equal([]patchOperation{{Op: "add", Path: "/spec/topologySpreadConstraints/-", Value: capacity}}))
+	})
+})
+
+var _ = Describe("metadataPatches", func() {
+	It("should add the maps if the pod has none", func() {
+		patches := metadataPatches(&metav1.ObjectMeta{}, map[string]string{"a/b": "1"}, map[string]string{"c": "2"})
+
+		Expect(patches).To(Equal([]patchOperation{
+			{Op: "add", Path: "/metadata/annotations", Value: map[string]string{"a/b": "1"}},
+			{Op: "add", Path: "/metadata/labels", Value: map[string]string{"c": "2"}},
+		}))
+	})
+
+	It("should escape keys and skip values that are already set", func() {
+		meta := &metav1.ObjectMeta{Annotations: map[string]string{"a/b": "0"}, Labels: map[string]string{"c": "2"}}
+		patches := metadataPatches(meta, map[string]string{"a/b": "1", "d~e": "3"}, map[string]string{"c": "2"})
+
+		Expect(patches).To(Equal([]patchOperation{
+			{Op: "add", Path: "/metadata/annotations/a~1b", Value: "1"},
+			{Op: "add", Path: "/metadata/annotations/d~0e", Value: "3"},
+		}))
+	})
+})
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// admittedPodsTTL is how long an admitted pod is counted for the spot ratio while it has not
+// reached the cache. Admissions of pods that are never created expire after it.
+const admittedPodsTTL = time.Minute
+
+// applySpotRatio splits the replicas of workloads that declare a spot ratio between spot and
+// on-demand nodes. A new pod targets spot nodes as long as less than the declared share of
+// its siblings does, so the distribution converges as pods are replaced. Siblings admitted
+// by the webhook that have not reached the cache yet are counted as well, so pods admitted
+// at the same time during a scale-up do not all target spot nodes.
+func (s *Server) applySpotRatio(owners []k8sClient.Owner, decision policy.Decision) policy.Decision {
+	if decision.Source == policy.SourcePod || s.cache == nil {
+		return decision
+	}
+
+	owner, ratio, found := spotRatioOwner(owners)
+	if !found {
+		return decision
+	}
+
+	siblings, err := s.cache.Siblings(owner)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not list pods of %s %s/%s. %v", owner.Kind, owner.Object.GetNamespace(), owner.Object.GetName(), err))
+		return decision
+	}
+
+	total, spot := 0, 0
+	for _, sibling := range siblings {
+		if sibling.DeletionTimestamp != nil || sibling.Status.Phase == corev1.PodSucceeded || sibling.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		total++
+		if s.targetsSpot(sibling) {
+			spot++
+		}
+	}
+	pendingTotal, pendingSpot := s.admitted.pending(owner.Object.GetUID(), siblings, s.targetsSpot)
+	total += pendingTotal
+	spot += pendingSpot
+
+	reason := fmt.Sprintf("%s %s spot ratio %d%% with %d of %d replicas on spot", owner.Kind, owner.Object.GetName(), ratio, spot, total)
+	if spot*100 >= ratio*(total+1) {
+		return decision.Override(policy.ModeSkip, policy.SourceOwner, reason)
+	}
+
+	mode := decision.Mode
+	if mode != policy.ModePrefer && mode != policy.ModeRequire {
+		mode = policy.ModePrefer
+	}
+	return decision.Override(mode, policy.SourceOwner, reason)
+}
+
+// recordAdmission remembers the admitted pod for the spot ratio of its workload until the pod
+// reaches the cache. Dry runs create no pods and are not recorded.
+func (s *Server) recordAdmission(request *admissionv1.AdmissionRequest, owners []k8sClient.Owner, spot bool) {
+	if request.DryRun != nil && *request.DryRun {
+		return
+	}
+	if owner, _, found := spotRatioOwner(owners); found {
+		s.admitted.add(owner.Object.GetUID(), spot, time.Now())
+	}
+}
+
+// spotRatioOwner returns the top-most owner declaring a valid spot ratio.
+func spotRatioOwner(owners []k8sClient.Owner) (k8sClient.Owner, int, bool) {
+	for i := len(owners) - 1; i >= 0; i-- {
+		ratio, found, err := policy.ParseSpotRatio(owners[i].Object)
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Ignoring spot ratio of %s %s/%s. %v", owners[i].Kind, owners[i].Object.GetNamespace(), owners[i].Object.GetName(), err))
+			continue
+		}
+		if found {
+			return owners[i], ratio, true
+		}
+	}
+	return k8sClient.Owner{}, 0, false
+}
+
+// targetsSpot reports whether the pod carries all tolerations the webhook injects.
+func (s *Server) targetsSpot(pod *corev1.Pod) bool {
+	for _, toleration := range s.config.Tolerations {
+		if !isTolerated(pod.Spec.Tolerations, toleration) {
+			return false
+		}
+	}
+	return true
+}
+
+// admittedPods tracks the pods admitted per owner. Mutating webhooks see new pods without name
+// and uid, so admitted pods are matched to the cache by their creation time: all siblings
+// created since the oldest tracked admission are taken as admitted ones that arrived.
+type admittedPods struct {
+	lock sync.Mutex
+	pods map[types.UID][]admittedPod
+}
+
+type admittedPod struct {
+	admitted time.Time
+	spot     bool
+}
+
+func newAdmittedPods() *admittedPods {
+	return &admittedPods{pods: map[types.UID][]admittedPod{}}
+}
+
+func (a *admittedPods) add(owner types.UID, spot bool, now time.Time) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.pods[owner] = append(a.pods[owner], admittedPod{admitted: now, spot: spot})
+}
+
+// pending returns the number of pods admitted for the owner and of those targeting spot nodes
+// that are not among the cached siblings yet.
+func (a *admittedPods) pending(owner types.UID, siblings []*corev1.Pod, targetsSpot func(*corev1.Pod) bool) (int, int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	pods := a.pods[owner]
+	for len(pods) > 0 && time.Since(pods[0].admitted) > admittedPodsTTL {
+		pods = pods[1:]
+	}
+	if len(pods) == 0 {
+		delete(a.pods, owner)
+		return 0, 0
+	}
+	a.pods[owner] = pods
+
+	total, spot := len(pods), 0
+	for _, pod := range pods {
+		if pod.spot {
+			spot++
+		}
+	}
+
+	// creation timestamps are truncated to seconds
+	since := pods[0].admitted.Truncate(time.Second)
+	for _, sibling := range siblings {
+		if sibling.CreationTimestamp.Time.Before(since) {
+			continue
+		}
+		total--
+		if targetsSpot(sibling) {
+			spot--
+		}
+	}
+	total = max(total, 0)
+	return total, min(max(spot, 0), total)
+}
@@ -0,0 +1,101 @@
+package http
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func deploymentWithRatio(name string, ratio string) []runtime.Object {
+	objects := deploymentObjects(name, 4)
+	objects[0].(*appsv1.Deployment).Annotations = map[string]string{policy.SpotRatioAnnotation: ratio}
+	return objects
+}
+
+func spotPod(name string, app string) *corev1.Pod {
+	pod := runningPod(name, app, "")
+	pod.Spec.Tolerations = []corev1.Toleration{config.SpotToleration}
+	return pod
+}
+
+var _ = Describe("spot ratio", func() {
+	var server *Server
+
+	newPod := func(app string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", app+"-1")}}
+	}
+
+	BeforeEach(func() {
+		objects := []runtime.Object{
+			namespaceWithMode("plain", nil, nil),
+			spotPod("balanced-1", "balanced"),
+			runningPod("balanced-2", "balanced", ""),
+			spotPod("spot-heavy-1", "spot-heavy"),
+			spotPod("spot-heavy-2", "spot-heavy"),
+			runningPod("spot-heavy-3", "spot-heavy", ""),
+			spotPod("invalid-1", "invalid"),
+		}
+		objects = append(objects, deploymentWithRatio("empty", "70")...)
+		objects = append(objects, deploymentWithRatio("balanced", "70")...)
+		objects = append(objects, deploymentWithRatio("spot-heavy", "50")...)
+		objects = append(objects, deploymentWithRatio("invalid", "150")...)
+		server = NewServer(config.NewConfig(), newTestCache(objects...))
+	})
+
+	It("should target spot for the first replica", func() {
+		decision := decideFor(server, "plain", newPod("empty"))
+
+		Expect(decision.Mode).To(Equal(policy.ModePrefer))
+		Expect(decision.Reason).To(Equal("Deployment empty spot ratio 70% with 0 of 0 replicas on spot"))
+	})
+
+	It("should target spot while the share is below the ratio", func() {
+		Expect(decideFor(server, "plain", newPod("balanced")).Mode).To(Equal(policy.ModePrefer))
+	})
+
+	It("should keep pods off spot once the share is reached", func() {
+		decision := decideFor(server, "plain", newPod("spot-heavy"))
+
+		Expect(decision.Mode).To(Equal(policy.ModeSkip))
+		Expect(decision.Reason).To(Equal("Deployment spot-heavy spot ratio 50% with 2 of 3 replicas on spot"))
+	})
+
+	It("should keep a required mode for spot replicas", func() {
+		pod := newPod("balanced")
+		server.config.DefaultMode = policy.ModeRequire
+
+		Expect(decideFor(server, "plain", pod).Mode).To(Equal(policy.ModeRequire))
+	})
+
+	It("should count admitted pods that have not reached the cache", func() {
+		modes := []policy.Mode{}
+		for range 4 {
+			modes = append(modes, server.mutatePod(admissionRequestFor("plain", newPod("empty"))).decision.Mode)
+		}
+
+		Expect(modes).To(Equal([]policy.Mode{policy.ModePrefer, policy.ModePrefer, policy.ModePrefer, policy.ModeSkip}))
+	})
+
+	It("should not count admitted pods that reached the cache twice", func() {
+		admitted := newAdmittedPods()
+		admitted.add("web", true, time.Now())
+		admitted.add("web", false, time.Now())
+		arrived := spotPod("web-1", "web")
+		arrived.CreationTimestamp = metav1.Now()
+
+		total, spot := admitted.pending("web", []*corev1.Pod{arrived}, server.targetsSpot)
+		Expect(total).To(Equal(1))
+		Expect(spot).To(Equal(0))
+	})
+
+	It("should ignore invalid ratios", func() {
+		Expect(decideFor(server, "plain", newPod("invalid")).Mode).To(Equal(policy.ModeTolerate))
+	})
+})
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+)
+
+// applyReplicaProtection keeps pods off spot nodes while their workload has too few replicas
+// or too few replicas running on on-demand nodes, unless the pod itself asks for the mode.
+func (s *Server) applyReplicaProtection(owners []k8sClient.Owner, decision policy.Decision) policy.Decision {
+	if decision.Mode == policy.ModeSkip || decision.Source == policy.SourcePod || s.cache == nil {
+		return decision
+	}
+	if s.config.MinReplicasForSpot == 0 && s.config.MinOnDemandReplicas == 0 {
+		return decision
+	}
+
+	owner, replicas, found := k8sClient.ReplicatedOwner(owners)
+	if !found {
+		return decision
+	}
+
+	if int(replicas) < s.config.MinReplicasForSpot {
+		decision.Mode = policy.ModeSkip
+		decision.Reason = fmt.Sprintf("%s, %s %s has less than %d replicas", decision.Reason, owner.Kind, owner.Object.GetName(), s.config.MinReplicasForSpot)
+		return decision
+	}
+
+	if s.config.MinOnDemandReplicas > 0 {
+		onDemand, err := s.cache.OnDemandReplicas(owner)
+		if err != nil {
+			slog.Error(fmt.Sprintf("Could not count on-demand replicas of %s %s/%s. %v", owner.Kind, owner.Object.GetNamespace(), owner.Object.GetName(), err))
+			return decision
+		}
+		if onDemand < s.config.MinOnDemandReplicas {
+			decision.Mode = policy.ModeSkip
+			decision.Reason = fmt.Sprintf("%s, %s %s has %d of %d replicas on on-demand nodes", decision.Reason, owner.Kind, owner.Object.GetName(), onDemand, s.config.MinOnDemandReplicas)
+		}
+	}
+	return decision
+}
@@ -0,0 +1,105 @@
+package http
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func deploymentObjects(name string, replicas int32) []runtime.Object {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}}
+	return []runtime.Object{
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "plain", UID: types.UID(name)},
+			Spec: appsv1.DeploymentSpec{Replicas: &replicas, Selector: selector}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: name + "-1", Namespace: "plain", UID: types.UID(name + "-1"),
+			OwnerReferences: controllerRef("apps/v1", "Deployment", name)},
+			Spec: appsv1.ReplicaSetSpec{Replicas: &replicas, Selector: selector}},
+	}
+}
+
+func runningPod(name string, app string, node string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "plain", Labels: map[string]string{"app": app}},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func node(name string, spot bool) *corev1.Node {
+	labels := map[string]string{}
+	if spot {
+		labels[config.SpotNodeLabelKey] = config.SpotNodeLabelValue
+	}
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+var _ = Describe("replica protection", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	newPod := func(app string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", app+"-1")}}
+	}
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		objects := []runtime.Object{
+			namespaceWithMode("plain", nil, nil),
+			node("spot-1", true),
+			node("regular-1", false),
+			runningPod("single-1", "single", "spot-1"),
+			runningPod("mixed-1", "mixed", "spot-1"),
+			runningPod("mixed-2", "mixed", "regular-1"),
+			runningPod("spot-only-1", "spot-only", "spot-1"),
+			runningPod("spot-only-2", "spot-only", "spot-1"),
+		}
+		objects = append(objects, deploymentObjects("single", 1)...)
+		objects = append(objects, deploymentObjects("mixed", 3)...)
+		objects = append(objects, deploymentObjects("spot-only", 3)...)
+		server = NewServer(cfg, newTestCache(objects...))
+	})
+
+	It("should not protect anything by default", func() {
+		Expect(decideFor(server, "plain", newPod("single")).Mode).To(Equal(policy.ModeTolerate))
+	})
+
+	It("should keep single-replica workloads off spot nodes", func() {
+		cfg.MinReplicasForSpot = 2
+		decision := decideFor(server, "plain", newPod("single"))
+
+		Expect(decision.Mode).To(Equal(policy.ModeSkip))
+		Expect(decision.Reason).To(Equal("default mode, Deployment single has less than 2 replicas"))
+		Expect(decideFor(server, "plain", newPod("mixed")).Mode).To(Equal(policy.ModeTolerate))
+	})
+
+	It("should keep pods off spot nodes until enough replicas run on on-demand nodes", func() {
+		cfg.MinOnDemandReplicas = 1
+		decision := decideFor(server, "plain", newPod("spot-only"))
+
+		Expect(decision.Mode).To(Equal(policy.ModeSkip))
+		Expect(decision.Reason).To(Equal("default mode, Deployment spot-only has 0 of 1 replicas on on-demand nodes"))
+		Expect(decideFor(server, "plain", newPod("mixed")).Mode).To(Equal(policy.ModeTolerate))
+	})
+
+	It("should respect the mode declared by the pod", func() {
+		cfg.MinReplicasForSpot = 2
+		pod := newPod("single")
+		pod.Annotations = map[string]string{policy.ModeAnnotation: "prefer"}
+
+		Expect(decideFor(server, "plain", pod).Mode).To(Equal(policy.ModePrefer))
+	})
+
+	It("should ignore pods without replicated owner", func() {
+		cfg.MinReplicasForSpot = 2
+
+		Expect(decideFor(server, "plain", &corev1.Pod{}).Mode).To(Equal(policy.ModeTolerate))
+	})
+})
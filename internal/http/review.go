@@ -0,0 +1,130 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// admitFunc answers an admission request. Requests of older versions are converted to v1.
+type admitFunc func(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error)
+
+// serveAdmission decodes an admission.k8s.io/v1 or v1beta1 AdmissionReview, passes its request
+// to admit and responds in the version of the request. Other versions are rejected with a
+// Status describing the supported versions.
+func serveAdmission(w http.ResponseWriter, r *http.Request, admit admitFunc) {
+	var body []byte
+	if r.Body != nil {
+		if data, err := io.ReadAll(r.Body); err == nil {
+			body = data
+		}
+	}
+
+	if len(body) == 0 {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, "empty body")
+		return
+	}
+
+	obj, gvk, err := codecs.UniversalDeserializer().Decode(body, nil, nil)
+	if err != nil {
+		if gvk != nil && gvk.Kind == "AdmissionReview" {
+			writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest,
+				fmt.Sprintf("unsupported admission review version %q, supported are %s and %s",
+					gvk.GroupVersion(), admissionv1.SchemeGroupVersion, admissionv1beta1.SchemeGroupVersion))
+			return
+		}
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("could not deserialize request: %v", err))
+		return
+	}
+
+	var request *admissionv1.AdmissionRequest
+	switch review := obj.(type) {
+	case *admissionv1.AdmissionReview:
+		request = review.Request
+	case *admissionv1beta1.AdmissionReview:
+		if review.Request != nil {
+			request = &admissionv1.AdmissionRequest{}
+			if err := convert(review.Request, request); err != nil {
+				writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("could not convert request: %v", err))
+				return
+			}
+		}
+	default:
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, fmt.Sprintf("expected an AdmissionReview, got %s", gvk))
+		return
+	}
+
+	if request == nil {
+		writeStatus(w, http.StatusBadRequest, metav1.StatusReasonBadRequest, "admission review contains no request")
+		return
+	}
+
+	response, err := admit(request)
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, err.Error())
+		return
+	}
+	response.UID = request.UID
+
+	var review interface{}
+	switch gvk.GroupVersion() {
+	case admissionv1beta1.SchemeGroupVersion:
+		v1beta1Response := &admissionv1beta1.AdmissionResponse{}
+		if err := convert(response, v1beta1Response); err != nil {
+			writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, fmt.Sprintf("could not convert response: %v", err))
+			return
+		}
+		review = admissionv1beta1.AdmissionReview{TypeMeta: typeMeta(*gvk), Response: v1beta1Response}
+	default:
+		review = admissionv1.AdmissionReview{TypeMeta: typeMeta(*gvk), Response: response}
+	}
+
+	respBytes, err := json.Marshal(review)
+	if err != nil {
+		writeStatus(w, http.StatusInternalServerError, metav1.StatusReasonInternalError, fmt.Sprintf("could not serialize response: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(respBytes); err != nil {
+		slog.Error(fmt.Sprintf("Could not write admission response: %v", err))
+	}
+}
+
+// convert copies between the versions of the admission types, which share their json representation.
+func convert(in interface{}, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func typeMeta(gvk schema.GroupVersionKind) metav1.TypeMeta {
+	apiVersion, kind := gvk.ToAPIVersionAndKind()
+	return metav1.TypeMeta{APIVersion: apiVersion, Kind: kind}
+}
+
+// writeStatus responds with a Status describing the error.
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	status := metav1.Status{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Status"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		slog.Error(fmt.Sprintf("Could not write error response: %v", err))
+	}
+}
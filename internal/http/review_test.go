@@ -0,0 +1,81 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("admission review versions", func() {
+	post := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "/mutate", bytes.NewReader([]byte(body)))
+		Expect(err).NotTo(HaveOccurred())
+		rr := httptest.NewRecorder()
+		NewServer(config.NewConfig(), nil).ServeHTTP(rr, req)
+		return rr
+	}
+
+	It("should respond to v1beta1 reviews in v1beta1", func() {
+		rr := post(`{
+			"apiVersion": "admission.k8s.io/v1beta1",
+			"kind": "AdmissionReview",
+			"request": {
+				"uid": "12345",
+				"kind": {"group": "", "version": "v1", "kind": "Pod"},
+				"resource": {"group": "", "version": "v1", "resource": "pods"},
+				"namespace": "default",
+				"operation": "CREATE",
+				"userInfo": {},
+				"object": {"metadata": {"name": "test-pod"}}
+			}
+		}`)
+
+		Expect(rr.Code).To(Equal(http.StatusOK))
+		review := admissionv1beta1.AdmissionReview{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &review)).To(Succeed())
+		Expect(review.APIVersion).To(Equal("admission.k8s.io/v1beta1"))
+		Expect(review.Kind).To(Equal("AdmissionReview"))
+		Expect(review.Response.UID).To(BeEquivalentTo("12345"))
+		Expect(review.Response.Allowed).To(BeTrue())
+		Expect(*review.Response.PatchType).To(Equal(admissionv1beta1.PatchTypeJSONPatch))
+		Expect(review.Response.Patch).NotTo(BeEmpty())
+	})
+
+	It("should respond to v1 reviews in v1", func() {
+		review := reviewPod(NewServer(config.NewConfig(), nil), `{"metadata": {"name": "test-pod"}}`)
+
+		Expect(review.APIVersion).To(Equal("admission.k8s.io/v1"))
+		Expect(review.Kind).To(Equal("AdmissionReview"))
+	})
+
+	It("should reject unknown versions with a status", func() {
+		rr := post(`{"apiVersion": "admission.k8s.io/v2", "kind": "AdmissionReview", "request": {"uid": "12345"}}`)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+		status := metav1.Status{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &status)).To(Succeed())
+		Expect(status.Status).To(Equal(metav1.StatusFailure))
+		Expect(status.Reason).To(Equal(metav1.StatusReasonBadRequest))
+		Expect(status.Code).To(BeEquivalentTo(http.StatusBadRequest))
+		Expect(status.Message).To(Equal(`unsupported admission review version "admission.k8s.io/v2", supported are admission.k8s.io/v1 and admission.k8s.io/v1beta1`))
+	})
+
+	It("should reject reviews without request", func() {
+		rr := post(`{"apiVersion": "admission.k8s.io/v1", "kind": "AdmissionReview"}`)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should reject other kinds", func() {
+		rr := post(`{"apiVersion": "v1", "kind": "Pod"}`)
+
+		Expect(rr.Code).To(Equal(http.StatusBadRequest))
+	})
+})
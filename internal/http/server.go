@@ -4,16 +4,25 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log/slog"
 	"net/http"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/util"
 	admissionv1 "k8s.io/api/admission/v1"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	toolscache "k8s.io/client-go/tools/cache"
 )
 
 var (
@@ -21,10 +30,41 @@ var (
 	codecs = serializer.NewCodecFactory(scheme)
 )
 
+func init() {
+	utilruntime.Must(admissionv1.AddToScheme(scheme))
+	utilruntime.Must(admissionv1beta1.AddToScheme(scheme))
+}
+
 type Server struct {
+	config   *config.Config
+	cache    *k8sClient.Cache
+	rules    *policy.Evaluator
+	admitted *admittedPods
+}
+
+func NewServer(cfg *config.Config, cache *k8sClient.Cache) *Server {
+	s := &Server{
+		config:   cfg,
+		cache:    cache,
+		rules:    policy.NewEvaluator(),
+		admitted: newAdmittedPods(),
+	}
+
+	if cache != nil {
+		err := cache.AddSpotPolicyHandler(toolscache.ResourceEventHandlerFuncs{
+			AddFunc:    s.loadSpotPolicy,
+			UpdateFunc: func(_, obj interface{}) { s.loadSpotPolicy(obj) },
+			DeleteFunc: s.forgetSpotPolicy,
+		})
+		if err != nil {
+			slog.Error(fmt.Sprintf("Could not watch SpotPolicies. %v", err))
+		}
+	}
+
+	return s
 }
 
-func StartHttpServer(cfg *config.Config, fileWatcher *util.SecretWatcher) *http.Server {
+func StartHttpServer(cfg *config.Config, fileWatcher *util.SecretWatcher, cache *k8sClient.Cache) *http.Server {
 	if cfg == nil {
 		cfg = config.NewConfig()
 	}
@@ -54,9 +94,15 @@ func StartHttpServer(cfg *config.Config, fileWatcher *util.SecretWatcher) *http.
 		},
 	}
 
+	webhook := NewServer(cfg, cache)
+	mux := http.NewServeMux()
+	mux.Handle("/", webhook)
+	mux.HandleFunc("/mutate-workloads", webhook.ServeWorkloads)
+	mux.HandleFunc("/validate", webhook.ServeValidate)
+
 	server := http.Server{
 		Addr:      "0.0.0.0:" + cfg.WebhookPort,
-		Handler:   &Server{},
+		Handler:   mux,
 		TLSConfig: tlsConfig,
 	}
 
@@ -73,60 +119,214 @@ func StartHttpServer(cfg *config.Config, fileWatcher *util.SecretWatcher) *http.
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var body []byte
-	if r.Body != nil {
-		if data, err := io.ReadAll(r.Body); err == nil {
-			body = data
+	serveAdmission(w, r, s.mutate)
+}
+
+func (s *Server) mutate(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	response := &admissionv1.AdmissionResponse{
+		UID:     request.UID,
+		Allowed: true,
+	}
+
+	if request.Kind.Kind == "Pod" {
+		if err := s.respond(request, response, s.mutatePod(request)); err != nil {
+			return nil, err
 		}
 	}
 
-	if len(body) == 0 {
-		http.Error(w, "empty body", http.StatusBadRequest)
-		return
+	return response, nil
+}
+
+// respond adds the warnings and patches of the mutation to the response or, if the namespace
+// is only audited, reports the patches.
+func (s *Server) respond(request *admissionv1.AdmissionRequest, response *admissionv1.AdmissionResponse, result mutation) error {
+	response.Warnings = append(response.Warnings, result.decision.Warnings...)
+	if len(result.patches) == 0 {
+		return nil
+	}
+
+	patch, err := json.Marshal(result.patches)
+	if err != nil {
+		return fmt.Errorf("could not serialize patch: %v", err)
+	}
+	if s.auditOnly(request.Namespace) {
+		audit(response, request.Kind.Kind, result, patch)
+	} else {
+		patchType := admissionv1.PatchTypeJSONPatch
+		response.Patch = patch
+		response.PatchType = &patchType
+	}
+	return nil
+}
+
+// mutation is the outcome of the admission of a pod or workload.
+type mutation struct {
+	decision policy.Decision
+	patches  []patchOperation
+}
+
+func (s *Server) mutatePod(request *admissionv1.AdmissionRequest) mutation {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return mutation{}
 	}
 
-	review := admissionv1.AdmissionReview{}
-	deserializer := codecs.UniversalDeserializer()
-	if _, _, err := deserializer.Decode(body, nil, &review); err != nil {
-		http.Error(w, fmt.Sprintf("could not deserialize request: %v", err), http.StatusBadRequest)
-		return
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(request.Object.Raw, &pod); err != nil {
+		slog.Error(fmt.Sprintf("Could not deserialize pod %s/%s: %v", request.Namespace, request.Name, err))
+		return mutation{}
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = request.Namespace
 	}
 
-	response := admissionv1.AdmissionReview{
-		TypeMeta: review.TypeMeta,
-		Response: &admissionv1.AdmissionResponse{
-			UID:     review.Request.UID,
-			Allowed: true,
-		},
+	if request.Operation == admissionv1.Update {
+		return s.mutateUpdate(request, &pod)
+	}
+
+	owners := s.getOwners(&pod)
+	decision := s.decide(request.Namespace, &pod, owners, request.UserInfo)
+	slog.Debug(fmt.Sprintf("Pod %s/%s is admitted with mode %s (%s)", request.Namespace, pod.Name, decision.Mode, decision.Reason))
+	if decision.Mode == policy.ModeSkip {
+		s.recordAdmission(request, owners, s.targetsSpot(&pod))
+		return mutation{decision: decision}
+	}
+
+	patches, decision := s.podPatches(&pod, owners, decision)
+	if len(patches) > 0 {
+		patches = append(patches, metadataPatches(&pod.ObjectMeta,
+			map[string]string{policy.DecisionAnnotation: decision.Annotation()},
+			map[string]string{policy.MutatedLabel: "true"})...)
+	}
+	s.recordAdmission(request, owners, s.targetsSpot(&pod) || !s.auditOnly(request.Namespace))
+	return mutation{decision: decision, patches: patches}
+}
+
+// podPatches returns the patches for the pod and the decision they apply. A pod pinned to
+// on-demand nodes cannot require spot nodes, it is only tolerated instead.
+func (s *Server) podPatches(pod *corev1.Pod, owners []k8sClient.Owner, decision policy.Decision) ([]patchOperation, policy.Decision) {
+	spec := pod.Spec.DeepCopy()
+	tolerations := append(s.tolerations(), decision.Tolerations...)
+	patches := tolerationPatches(spec, tolerations)
+
+	switch decision.Mode {
+	case policy.ModePrefer:
+		patches = append(patches, preferredAffinityPatches(spec, s.spotPreference())...)
+	case policy.ModeRequire:
+		err := s.onDemandPoolPin(spec)
+		affinityPatches := []patchOperation{}
+		if err == nil {
+			affinityPatches, err = requiredAffinityPatches(spec, []corev1.NodeSelectorRequirement{spotRequirement()})
+		}
+		if err != nil {
+			slog.Warn(fmt.Sprintf("Not requiring spot nodes for pod %s/%s. %v", pod.Namespace, pod.Name, err))
+			decision.Mode = policy.ModeTolerate
+			decision.Reason = fmt.Sprintf("%s, spot nodes are not required as %v", decision.Reason, err)
+		}
+		patches = append(patches, affinityPatches...)
 	}
 
-	if review.Request.Kind.Kind == "Pod" {
-		patch := `[
-			{
-				"op": "add",
-				"path": "/spec/tolerations",
-				"value": [
-					{
-						"key": "kubernetes.azure.com/scalesetpriority",
-						"operator": "Equal",
-						"value": "spot",
-						"effect": "NoSchedule"
-					}
-				]
+	if affinity := decision.NodeAffinity; affinity != nil {
+		for _, term := range affinity.PreferredDuringSchedulingIgnoredDuringExecution {
+			patches = append(patches, preferredAffinityPatches(spec, term)...)
+		}
+		if required := affinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil && len(required.NodeSelectorTerms) > 0 {
+			affinityPatches, err := requiredAffinityPatches(spec, required.NodeSelectorTerms[0].MatchExpressions)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Not adding required node affinity of rule %s to pod %s/%s. %v", decision.Rule, pod.Namespace, pod.Name, err))
 			}
-		]`
-		patchType := admissionv1.PatchTypeJSONPatch
-		response.Response.Patch = []byte(patch)
-		response.Response.PatchType = &patchType
+			patches = append(patches, affinityPatches...)
+		}
 	}
 
-	respBytes, err := json.Marshal(response)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("could not serialize response: %v", err), http.StatusInternalServerError)
-		return
+	if constraints := s.topologySpread(pod, owners); len(constraints) > 0 {
+		patches = append(patches, topologySpreadPatches(spec, constraints)...)
+	}
+
+	return patches, decision
+}
+
+// onDemandPoolPin returns an error if the pod pins itself through the agentpool label to node
+// pools without spot nodes, by node selector or by a term of its required node affinity.
+// Pools without nodes are unknown and not considered on-demand.
+func (s *Server) onDemandPoolPin(spec *corev1.PodSpec) error {
+	if s.cache == nil {
+		return nil
+	}
+
+	onDemandOnly := func(pools []string) bool {
+		for _, pool := range pools {
+			nodes, err := s.cache.Nodes().List(labels.SelectorFromSet(labels.Set{config.AgentPoolLabelKey: pool}))
+			if err != nil {
+				slog.Error(fmt.Sprintf("Could not list nodes of node pool %s. %v", pool, err))
+				return false
+			}
+			if len(nodes) == 0 || slices.ContainsFunc(nodes, k8sClient.IsSpotNode) {
+				return false
+			}
+		}
+		return len(pools) > 0
+	}
+
+	if pool, exists := spec.NodeSelector[config.AgentPoolLabelKey]; exists && onDemandOnly([]string{pool}) {
+		return fmt.Errorf("node selector %s=%s pins the pod to an on-demand node pool", config.AgentPoolLabelKey, pool)
 	}
+	if spec.Affinity == nil || spec.Affinity.NodeAffinity == nil || spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return nil
+	}
+	for i, term := range spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		for _, expression := range term.MatchExpressions {
+			if expression.Key == config.AgentPoolLabelKey && expression.Operator == corev1.NodeSelectorOpIn && onDemandOnly(expression.Values) {
+				return fmt.Errorf("node selector term %d pins the pod to on-demand node pools %s", i, strings.Join(expression.Values, ", "))
+			}
+		}
+	}
+	return nil
+}
 
-	if _, err := w.Write(respBytes); err != nil {
-		http.Error(w, fmt.Sprintf("could not write response: %v", err), http.StatusInternalServerError)
+// topologySpread returns the configured topology spread constraints with the label selector
+// of the pod. The selector is taken from the constraints the pod already declares or from
+// its owners. Without selector the pod is not spread.
+func (s *Server) topologySpread(pod *corev1.Pod, owners []k8sClient.Owner) []corev1.TopologySpreadConstraint {
+	if len(s.config.TopologySpread) == 0 {
+		return nil
+	}
+
+	var selector *metav1.LabelSelector
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		if constraint.LabelSelector != nil {
+			selector = constraint.LabelSelector
+			break
+		}
+	}
+	for i := len(owners) - 1; i >= 0 && selector == nil; i-- {
+		selector = owners[i].LabelSelector()
+	}
+	if selector == nil {
+		slog.Debug(fmt.Sprintf("Not spreading pod %s/%s. No label selector found", pod.Namespace, pod.Name))
+		return nil
+	}
+
+	constraints := make([]corev1.TopologySpreadConstraint, 0, len(s.config.TopologySpread))
+	for _, constraint := range s.config.TopologySpread {
+		constraint.LabelSelector = selector
+		constraints = append(constraints, constraint)
+	}
+	return constraints
+}
+
+func spotRequirement() corev1.NodeSelectorRequirement {
+	return corev1.NodeSelectorRequirement{
+		Key:      config.SpotNodeLabelKey,
+		Operator: corev1.NodeSelectorOpIn,
+		Values:   []string{config.SpotNodeLabelValue},
+	}
+}
+
+func (s *Server) spotPreference() corev1.PreferredSchedulingTerm {
+	return corev1.PreferredSchedulingTerm{
+		Weight: s.config.SpotAffinityWeight,
+		Preference: corev1.NodeSelectorTerm{
+			MatchExpressions: []corev1.NodeSelectorRequirement{spotRequirement()},
+		},
 	}
 }
@@ -17,9 +17,13 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/util"
 	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
@@ -56,7 +60,7 @@ var _ = Describe("Server", func() {
 			err := mockFileWatcher.WatchSecret()
 			Expect(err).NotTo(HaveOccurred())
 
-			StartHttpServer(mockCfg, mockFileWatcher)
+			StartHttpServer(mockCfg, mockFileWatcher, nil)
 
 			time.Sleep(1 * time.Second)
 
@@ -81,7 +85,7 @@ var _ = Describe("Server", func() {
 			err = mockFileWatcher.WatchSecret()
 			Expect(err).NotTo(HaveOccurred())
 
-			StartHttpServer(mockCfg, mockFileWatcher)
+			StartHttpServer(mockCfg, mockFileWatcher, nil)
 
 			time.Sleep(1 * time.Second)
 
@@ -113,7 +117,7 @@ var _ = Describe("Server", func() {
 			Expect(err).NotTo(HaveOccurred())
 
 			rr := httptest.NewRecorder()
-			handler := http.HandlerFunc((&Server{}).ServeHTTP)
+			handler := http.HandlerFunc(NewServer(config.NewConfig(), nil).ServeHTTP)
 
 			handler.ServeHTTP(rr, req)
 
@@ -121,43 +125,128 @@ var _ = Describe("Server", func() {
 		})
 
 		It("should return admissionreview with added toleration ", func() {
-			//Create a sample admission review request
-			request := admissionv1.AdmissionReview{
-				Request: &admissionv1.AdmissionRequest{
-					UID: "12345",
-					Kind: metav1.GroupVersionKind{
-						Group:   "",
-						Version: "v1",
-						Kind:    "Pod",
-					},
-					Object: runtime.RawExtension{
-						Raw: []byte(`{"metadata": {"name": "test-pod"}}`),
-					},
-				},
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{"metadata": {"name": "test-pod"}}`)
+
+			Expect(response.Response).NotTo(BeNil())
+			Expect(response.Response.Patch).NotTo(BeNil())
+
+			expectedPatch := `[
+			{
+				"op": "add",
+				"path": "/spec/tolerations",
+				"value": [
+					{
+						"key": "kubernetes.azure.com/scalesetpriority",
+						"operator": "Equal",
+						"value": "spot",
+						"effect": "NoSchedule"
+					}
+				]
+			},
+			{"op": "add", "path": "/metadata/annotations", "value": {"spot-tolerator.stein.solutions/decision": "{\"mode\":\"tolerate\",\"reason\":\"default mode\"}"}},
+			{"op": "add", "path": "/metadata/labels", "value": {"spot-tolerator.stein.solutions/mutated": "true"}}
+		]`
+			Expect(string(response.Response.Patch)).To(MatchJSON(expectedPatch))
+		})
+
+		It("should append the toleration to existing tolerations", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod"},
+				"spec": {"tolerations": [{"key": "node.kubernetes.io/not-ready", "operator": "Exists", "effect": "NoExecute", "tolerationSeconds": 300}]}
+			}`)
+
+			Expect(response.Response).NotTo(BeNil())
+			expectedPatch := `[
+			{
+				"op": "add",
+				"path": "/spec/tolerations/-",
+				"value": {
+					"key": "kubernetes.azure.com/scalesetpriority",
+					"operator": "Equal",
+					"value": "spot",
+					"effect": "NoSchedule"
+				}
+			},
+			{"op": "add", "path": "/metadata/annotations", "value": {"spot-tolerator.stein.solutions/decision": "{\"mode\":\"tolerate\",\"reason\":\"default mode\"}"}},
+			{"op": "add", "path": "/metadata/labels", "value": {"spot-tolerator.stein.solutions/mutated": "true"}}
+		]`
+			Expect(string(response.Response.Patch)).To(MatchJSON(expectedPatch))
+		})
+
+		It("should inject all configured tolerations", func() {
+			cfg := config.NewConfig()
+			cfg.Tolerations = []corev1.Toleration{
+				config.SpotToleration,
+				{Key: "workload-class", Operator: corev1.TolerationOpEqual, Value: "batch", Effect: corev1.TaintEffectNoSchedule},
 			}
+			response := reviewPod(NewServer(cfg, nil), `{"metadata": {"name": "test-pod"}}`)
 
-			requestBytes, err := json.Marshal(request)
-			Expect(err).NotTo(HaveOccurred())
+			Expect(response.Response).NotTo(BeNil())
+			expectedPatch := `[
+			{
+				"op": "add",
+				"path": "/spec/tolerations",
+				"value": [
+					{
+						"key": "kubernetes.azure.com/scalesetpriority",
+						"operator": "Equal",
+						"value": "spot",
+						"effect": "NoSchedule"
+					},
+					{
+						"key": "workload-class",
+						"operator": "Equal",
+						"value": "batch",
+						"effect": "NoSchedule"
+					}
+				]
+			},
+			{"op": "add", "path": "/metadata/annotations", "value": {"spot-tolerator.stein.solutions/decision": "{\"mode\":\"tolerate\",\"reason\":\"default mode\"}"}},
+			{"op": "add", "path": "/metadata/labels", "value": {"spot-tolerator.stein.solutions/mutated": "true"}}
+		]`
+			Expect(string(response.Response.Patch)).To(MatchJSON(expectedPatch))
+		})
 
-			req, err := http.NewRequest("POST", "/mutate", bytes.NewReader(requestBytes))
-			Expect(err).NotTo(HaveOccurred())
+		It("should not patch a pod that already tolerates spot nodes", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod"},
+				"spec": {"tolerations": [{"key": "kubernetes.azure.com/scalesetpriority", "operator": "Exists"}]}
+			}`)
 
-			rr := httptest.NewRecorder()
-			handler := http.HandlerFunc((&Server{}).ServeHTTP)
+			Expect(response.Response).NotTo(BeNil())
+			Expect(response.Response.Allowed).To(BeTrue())
+			Expect(response.Response.Patch).To(BeNil())
+			Expect(response.Response.PatchType).To(BeNil())
+		})
 
-			handler.ServeHTTP(rr, req)
+		It("should not patch a pod that opts out through its annotation", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod", "annotations": {"spot-tolerator.stein.solutions/mode": "skip"}}
+			}`)
 
-			Expect(rr.Code).To(Equal(200))
+			Expect(response.Response).NotTo(BeNil())
+			Expect(response.Response.Allowed).To(BeTrue())
+			Expect(response.Response.Patch).To(BeNil())
+		})
 
-			respBody, err := io.ReadAll(rr.Body)
-			Expect(err).NotTo(HaveOccurred())
+		It("should ignore an unknown mode annotation", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod", "annotations": {"spot-tolerator.stein.solutions/mode": "sometimes"}}
+			}`)
 
-			response := admissionv1.AdmissionReview{}
-			err = json.Unmarshal(respBody, &response)
-			Expect(err).NotTo(HaveOccurred())
 			Expect(response.Response).NotTo(BeNil())
 			Expect(response.Response.Patch).NotTo(BeNil())
+		})
 
+		It("should prefer spot nodes in prefer mode", func() {
+			cfg := config.NewConfig()
+			cfg.SpotAffinityWeight = 42
+			response := reviewPod(NewServer(cfg, nil), `{
+				"metadata": {"name": "test-pod", "annotations": {"spot-tolerator.stein.solutions/mode": "prefer"}},
+				"spec": {"affinity": {"podAntiAffinity": {}}}
+			}`)
+
+			Expect(response.Response).NotTo(BeNil())
 			expectedPatch := `[
 			{
 				"op": "add",
@@ -170,13 +259,323 @@ var _ = Describe("Server", func() {
 						"effect": "NoSchedule"
 					}
 				]
-			}
+			},
+			{
+				"op": "add",
+				"path": "/spec/affinity/nodeAffinity",
+				"value": {
+					"preferredDuringSchedulingIgnoredDuringExecution": [
+						{
+							"weight": 42,
+							"preference": {
+								"matchExpressions": [
+									{"key": "kubernetes.azure.com/scalesetpriority", "operator": "In", "values": ["spot"]}
+								]
+							}
+						}
+					]
+				}
+			},
+			{"op": "add", "path": "/metadata/annotations/spot-tolerator.stein.solutions~1decision", "value": "{\"mode\":\"prefer\",\"reason\":\"pod annotation\"}"},
+			{"op": "add", "path": "/metadata/labels", "value": {"spot-tolerator.stein.solutions/mutated": "true"}}
 		]`
-			Expect(string(response.Response.Patch)).To(Equal(expectedPatch))
+			Expect(string(response.Response.Patch)).To(MatchJSON(expectedPatch))
 		})
+
+		It("should require spot nodes in require mode", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod", "annotations": {"spot-tolerator.stein.solutions/mode": "require"}},
+				"spec": {"tolerations": [{"key": "gpu", "operator": "Exists"}]}
+			}`)
+
+			Expect(response.Response).NotTo(BeNil())
+			expectedPatch := `[
+			{
+				"op": "add",
+				"path": "/spec/tolerations/-",
+				"value": {
+					"key": "kubernetes.azure.com/scalesetpriority",
+					"operator": "Equal",
+					"value": "spot",
+					"effect": "NoSchedule"
+				}
+			},
+			{
+				"op": "add",
+				"path": "/spec/affinity",
+				"value": {
+					"nodeAffinity": {
+						"requiredDuringSchedulingIgnoredDuringExecution": {
+							"nodeSelectorTerms": [
+								{
+									"matchExpressions": [
+										{"key": "kubernetes.azure.com/scalesetpriority", "operator": "In", "values": ["spot"]}
+									]
+								}
+							]
+						}
+					}
+				}
+			},
+			{"op": "add", "path": "/metadata/annotations/spot-tolerator.stein.solutions~1decision", "value": "{\"mode\":\"require\",\"reason\":\"pod annotation\"}"},
+			{"op": "add", "path": "/metadata/labels", "value": {"spot-tolerator.stein.solutions/mutated": "true"}}
+		]`
+			Expect(string(response.Response.Patch)).To(MatchJSON(expectedPatch))
+		})
+
+		It("should only add tolerations if a required pod is pinned to on-demand nodes", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod", "annotations": {"spot-tolerator.stein.solutions/mode": "require"}},
+				"spec": {"nodeSelector": {"kubernetes.azure.com/scalesetpriority": "regular"}}
+			}`)
+
+			Expect(response.Response).NotTo(BeNil())
+			patches := []patchOperation{}
+			Expect(json.Unmarshal(response.Response.Patch, &patches)).To(Succeed())
+			Expect(patches).To(HaveLen(3))
+			Expect(patches[0].Path).To(Equal("/spec/tolerations"))
+			Expect(patches[1]).To(Equal(patchOperation{Op: "add", Path: "/metadata/annotations/spot-tolerator.stein.solutions~1decision",
+				Value: `{"mode":"tolerate","reason":"pod annotation, spot nodes are not required as node selector kubernetes.azure.com/scalesetpriority=regular conflicts with the required node affinity"}`}))
+			Expect(patches[2].Path).To(Equal("/metadata/labels"))
+		})
+
+		It("should record the decision on the pod", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod", "labels": {"app": "web", "spot-tolerator.stein.solutions/mutated": "true"}, "annotations": {}}
+			}`)
+
+			Expect(response.Response).NotTo(BeNil())
+			patches := []patchOperation{}
+			Expect(json.Unmarshal(response.Response.Patch, &patches)).To(Succeed())
+			Expect(patches).To(HaveLen(2))
+			Expect(patches[1]).To(Equal(patchOperation{
+				Op:    "add",
+				Path:  "/metadata/annotations/spot-tolerator.stein.solutions~1decision",
+				Value: `{"mode":"tolerate","reason":"default mode"}`,
+			}))
+		})
+
+		It("should not record a decision on pods that are not mutated", func() {
+			response := reviewPod(NewServer(config.NewConfig(), nil), `{
+				"metadata": {"name": "test-pod"},
+				"spec": {"tolerations": [{"operator": "Exists"}]}
+			}`)
+
+			Expect(response.Response.Patch).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("topology spread", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		cfg.TopologySpread = []corev1.TopologySpreadConstraint{
+			{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.ScheduleAnyway},
+		}
+		server = NewServer(cfg, newTestCache(deploymentObjects("web", 3)...))
+	})
+
+	It("should spread pods with the selector of their owner", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", "web-1")}}
+		constraints := server.topologySpread(pod, server.getOwners(pod))
+
+		Expect(constraints).To(HaveLen(1))
+		Expect(constraints[0].LabelSelector).To(Equal(&metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}))
+	})
+
+	It("should reuse the selector of existing constraints", func() {
+		selector := &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "frontend"}}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", "web-1")},
+			Spec: corev1.PodSpec{TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+				{MaxSkew: 1, TopologyKey: "kubernetes.io/hostname", WhenUnsatisfiable: corev1.DoNotSchedule, LabelSelector: selector},
+			}},
+		}
+		constraints := server.topologySpread(pod, server.getOwners(pod))
+
+		Expect(constraints).To(HaveLen(1))
+		Expect(constraints[0].LabelSelector).To(Equal(selector))
+	})
+
+	It("should not spread pods without selector", func() {
+		Expect(server.topologySpread(&corev1.Pod{}, nil)).To(BeEmpty())
+	})
+
+	It("should not spread pods that are not tolerated", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "plain", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", "web-1")}}
+		cfg.DefaultMode = policy.ModeSkip
+
+		Expect(server.mutatePod(admissionRequestFor("plain", pod)).patches).To(BeEmpty())
+	})
+})
+
+var _ = Describe("agentpool pins", func() {
+	var server *Server
+
+	BeforeEach(func() {
+		poolNode := func(name string, pool string, spot bool) *corev1.Node {
+			node := node(name, spot)
+			node.Labels[config.AgentPoolLabelKey] = pool
+			return node
+		}
+		server = NewServer(config.NewConfig(), newTestCache(poolNode("system-1", "system", false), poolNode("spot-1", "spot", true)))
+	})
+
+	It("should not require spot nodes for pods pinned to on-demand pools", func() {
+		requirePod := func(spec corev1.PodSpec) mutation {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default",
+				Annotations: map[string]string{policy.ModeAnnotation: "require"}}, Spec: spec}
+			return server.mutatePod(admissionRequestFor("default", pod))
+		}
+		affinityTo := func(pools ...string) *corev1.Affinity {
+			return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{
+					{Key: config.AgentPoolLabelKey, Operator: corev1.NodeSelectorOpIn, Values: pools},
+				}}},
+			}}}
+		}
+
+		result := requirePod(corev1.PodSpec{NodeSelector: map[string]string{config.AgentPoolLabelKey: "system"}})
+		Expect(result.decision.Mode).To(Equal(policy.ModeTolerate))
+		Expect(result.decision.Reason).To(Equal("pod annotation, spot nodes are not required as node selector kubernetes.azure.com/agentpool=system pins the pod to an on-demand node pool"))
+		Expect(result.patches).To(ContainElement(patchOperation{Op: "add", Path: "/metadata/annotations/spot-tolerator.stein.solutions~1decision",
+			Value: result.decision.Annotation()}))
+
+		Expect(requirePod(corev1.PodSpec{Affinity: affinityTo("system")}).decision.Mode).To(Equal(policy.ModeTolerate))
+		Expect(requirePod(corev1.PodSpec{Affinity: affinityTo("system", "spot")}).decision.Mode).To(Equal(policy.ModeRequire))
+		Expect(requirePod(corev1.PodSpec{NodeSelector: map[string]string{config.AgentPoolLabelKey: "spot"}}).decision.Mode).To(Equal(policy.ModeRequire))
+		Expect(requirePod(corev1.PodSpec{NodeSelector: map[string]string{config.AgentPoolLabelKey: "unknown"}}).decision.Mode).To(Equal(policy.ModeRequire))
+	})
+})
+
+var _ = Describe("spot policies", func() {
+	It("should inject the tolerations and node affinity of the matching rule", func() {
+		server := NewServer(config.NewConfig(), newTestCache(
+			&v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: "batch"}, Spec: v1alpha1.SpotPolicySpec{Rules: []v1alpha1.SpotPolicyRule{{
+				Action:      v1alpha1.ActionPrefer,
+				Tolerations: []corev1.Toleration{{Key: "workload-class", Operator: corev1.TolerationOpEqual, Value: "batch", Effect: corev1.TaintEffectNoSchedule}},
+				NodeAffinity: &corev1.NodeAffinity{RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "workload-class", Operator: corev1.NodeSelectorOpIn, Values: []string{"batch"}}},
+				}}}},
+			}}}},
+		))
+
+		response := reviewPod(server, `{"metadata": {"name": "test-pod"}, "spec": {}}`)
+
+		Expect(response.Response).NotTo(BeNil())
+		expectedPatch := `[
+			{
+				"op": "add",
+				"path": "/spec/tolerations",
+				"value": [
+					{"key": "kubernetes.azure.com/scalesetpriority", "operator": "Equal", "value": "spot", "effect": "NoSchedule"},
+					{"key": "workload-class", "operator": "Equal", "value": "batch", "effect": "NoSchedule"}
+				]
+			},
+			{
+				"op": "add",
+				"path": "/spec/affinity",
+				"value": {
+					"nodeAffinity": {
+						"preferredDuringSchedulingIgnoredDuringExecution": [
+							{"weight": 100, "preference": {"matchExpressions": [{"key": "kubernetes.azure.com/scalesetpriority", "operator": "In", "values": ["spot"]}]}}
+						]
+					}
+				}
+			},
+			{
+				"op": "add",
+				"path": "/spec/affinity/nodeAffinity/requiredDuringSchedulingIgnoredDuringExecution",
+				"value": {
+					"nodeSelectorTerms": [
+						{"matchExpressions": [{"key": "workload-class", "operator": "In", "values": ["batch"]}]}
+					]
+				}
+			},
+			{"op": "add", "path": "/metadata/annotations", "value": {"spot-tolerator.stein.solutions/decision": "{\"mode\":\"prefer\",\"rule\":\"batch/0\",\"reason\":\"rule batch/0\"}"}},
+			{"op": "add", "path": "/metadata/labels", "value": {"spot-tolerator.stein.solutions/mutated": "true"}}
+		]`
+		Expect(string(response.Response.Patch)).To(MatchJSON(expectedPatch))
+	})
+
+	It("should match expressions against the user of the request", func() {
+		server := NewServer(config.NewConfig(), newTestCache(
+			&v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: "ci"}, Spec: v1alpha1.SpotPolicySpec{Rules: []v1alpha1.SpotPolicyRule{{
+				Action: v1alpha1.ActionSkip,
+				Match:  []string{`request.userInfo.username == "system:serviceaccount:ci:runner"`},
+			}}}},
+		))
+		request := admissionRequestFor("default", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test-pod"}})
+
+		Expect(server.mutatePod(request).patches).NotTo(BeEmpty())
+
+		request.UserInfo = authenticationv1.UserInfo{Username: "system:serviceaccount:ci:runner"}
+		Expect(server.mutatePod(request).patches).To(BeEmpty())
 	})
 })
 
+func admissionRequestFor(namespace string, pod *corev1.Pod) *admissionv1.AdmissionRequest {
+	raw, err := json.Marshal(pod)
+	Expect(err).NotTo(HaveOccurred())
+	return &admissionv1.AdmissionRequest{
+		UID:       "12345",
+		Operation: admissionv1.Create,
+		Namespace: namespace,
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Object:    runtime.RawExtension{Raw: raw},
+	}
+}
+
+func reviewPod(server *Server, pod string) admissionv1.AdmissionReview {
+	return reviewPodIn(server, "default", pod)
+}
+
+func reviewPodIn(server *Server, namespace string, pod string) admissionv1.AdmissionReview {
+	request := admissionv1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "12345",
+			Operation: admissionv1.Create,
+			Namespace: namespace,
+			Kind: metav1.GroupVersionKind{
+				Group:   "",
+				Version: "v1",
+				Kind:    "Pod",
+			},
+			Object: runtime.RawExtension{
+				Raw: []byte(pod),
+			},
+		},
+	}
+
+	requestBytes, err := json.Marshal(request)
+	Expect(err).NotTo(HaveOccurred())
+
+	req, err := http.NewRequest("POST", "/mutate", bytes.NewReader(requestBytes))
+	Expect(err).NotTo(HaveOccurred())
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.ServeHTTP)
+
+	handler.ServeHTTP(rr, req)
+
+	Expect(rr.Code).To(Equal(200))
+
+	respBody, err := io.ReadAll(rr.Body)
+	Expect(err).NotTo(HaveOccurred())
+
+	response := admissionv1.AdmissionReview{}
+	err = json.Unmarshal(respBody, &response)
+	Expect(err).NotTo(HaveOccurred())
+
+	return response
+}
+
 // GetFreePort asks the kernel for a free open port that is ready to use.
 func GetFreePort() (int, error) {
 	addr, err := net.ResolveTCPAddr("tcp", "localhost:0")
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// mutateUpdate keeps the mutation applied on creation of an updated pod. The pod is not
+// decided again: pods admitted without mutation, e.g. skipped or created before the webhook,
+// stay unmutated. Tolerations, the decision annotation and the mutated label that the update
+// drops compared to the old pod are restored.
+func (s *Server) mutateUpdate(request *admissionv1.AdmissionRequest, pod *corev1.Pod) mutation {
+	oldPod := corev1.Pod{}
+	if err := json.Unmarshal(request.OldObject.Raw, &oldPod); err != nil {
+		slog.Error(fmt.Sprintf("Could not deserialize old pod %s/%s: %v", request.Namespace, request.Name, err))
+		return mutation{}
+	}
+
+	recorded, exists := oldPod.Annotations[policy.DecisionAnnotation]
+	if !exists {
+		return mutation{}
+	}
+	mode, _ := policy.RecordedMode(&oldPod)
+	decision := policy.Decision{Mode: mode, Reason: "decision recorded on creation"}
+
+	patches := tolerationPatches(pod.Spec.DeepCopy(), oldPod.Spec.Tolerations)
+
+	labels := map[string]string{}
+	if oldPod.Labels[policy.MutatedLabel] == "true" {
+		labels[policy.MutatedLabel] = "true"
+	}
+	patches = append(patches, metadataPatches(&pod.ObjectMeta, map[string]string{policy.DecisionAnnotation: recorded}, labels)...)
+	return mutation{decision: decision, patches: patches}
+}
@@ -0,0 +1,94 @@
+package http
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var _ = Describe("pod updates", func() {
+	var (
+		server *Server
+		oldPod *corev1.Pod
+	)
+
+	BeforeEach(func() {
+		server = NewServer(config.NewConfig(), nil)
+		oldPod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "test-pod",
+				Namespace:   "default",
+				Labels:      map[string]string{"app": "web", policy.MutatedLabel: "true"},
+				Annotations: map[string]string{policy.ModeAnnotation: "prefer", policy.DecisionAnnotation: `{"mode":"prefer","reason":"recorded"}`},
+			},
+			Spec: corev1.PodSpec{
+				NodeName:    "node-1",
+				Tolerations: []corev1.Toleration{config.SpotToleration},
+				Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{server.spotPreference()},
+				}},
+			},
+		}
+	})
+
+	It("should not patch a pod that was mutated on creation", func() {
+		pod := oldPod.DeepCopy()
+		pod.Labels["version"] = "2"
+
+		Expect(server.mutatePod(updateRequestFor(oldPod, pod)).patches).To(BeEmpty())
+	})
+
+	It("should restore the decision if the update drops it", func() {
+		pod := oldPod.DeepCopy()
+		pod.Labels = map[string]string{"app": "web"}
+		delete(pod.Annotations, policy.DecisionAnnotation)
+
+		Expect(server.mutatePod(updateRequestFor(oldPod, pod)).patches).To(Equal([]patchOperation{
+			{Op: "add", Path: "/metadata/annotations/spot-tolerator.stein.solutions~1decision", Value: `{"mode":"prefer","reason":"recorded"}`},
+			{Op: "add", Path: "/metadata/labels/spot-tolerator.stein.solutions~1mutated", Value: "true"},
+		}))
+	})
+
+	It("should restore tolerations the update drops", func() {
+		pod := oldPod.DeepCopy()
+		pod.Spec.Tolerations = nil
+
+		Expect(server.mutatePod(updateRequestFor(oldPod, pod)).patches).To(Equal([]patchOperation{
+			{Op: "add", Path: "/spec/tolerations", Value: []corev1.Toleration{config.SpotToleration}},
+		}))
+	})
+
+	It("should not mutate pods admitted without decision", func() {
+		oldPod.Labels = nil
+		oldPod.Annotations = map[string]string{policy.ModeAnnotation: "require"}
+		oldPod.Spec.Tolerations = nil
+		oldPod.Spec.Affinity = nil
+		pod := oldPod.DeepCopy()
+		pod.Labels = map[string]string{"version": "2"}
+
+		Expect(server.mutatePod(updateRequestFor(oldPod, pod)).patches).To(BeEmpty())
+	})
+
+	It("should ignore other operations", func() {
+		request := updateRequestFor(oldPod, oldPod)
+		request.Operation = admissionv1.Delete
+
+		Expect(server.mutatePod(request).patches).To(BeEmpty())
+	})
+})
+
+func updateRequestFor(oldPod *corev1.Pod, pod *corev1.Pod) *admissionv1.AdmissionRequest {
+	oldRaw, err := json.Marshal(oldPod)
+	Expect(err).NotTo(HaveOccurred())
+	request := admissionRequestFor(pod.Namespace, pod)
+	request.Operation = admissionv1.Update
+	request.OldObject = runtime.RawExtension{Raw: oldRaw}
+	return request
+}
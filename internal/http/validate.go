@@ -0,0 +1,180 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServeValidate rejects pods violating the placement of their namespace.
+func (s *Server) ServeValidate(w http.ResponseWriter, r *http.Request) {
+	serveAdmission(w, r, s.validate)
+}
+
+// validate checks created pods and pods whose tolerations are changed. Violations are only
+// reported as warnings if configured or if the namespace is audited.
+func (s *Server) validate(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	response := &admissionv1.AdmissionResponse{
+		UID:     request.UID,
+		Allowed: true,
+	}
+
+	if request.Kind.Kind != "Pod" || (request.Operation != admissionv1.Create && request.Operation != admissionv1.Update) {
+		return response, nil
+	}
+
+	pod := corev1.Pod{}
+	if err := json.Unmarshal(request.Object.Raw, &pod); err != nil {
+		return nil, fmt.Errorf("could not deserialize pod: %v", err)
+	}
+	if pod.Namespace == "" {
+		pod.Namespace = request.Namespace
+	}
+
+	if request.Operation == admissionv1.Update {
+		oldPod := corev1.Pod{}
+		if err := json.Unmarshal(request.OldObject.Raw, &oldPod); err != nil {
+			return nil, fmt.Errorf("could not deserialize old pod: %v", err)
+		}
+		if equality.Semantic.DeepEqual(oldPod.Spec.Tolerations, pod.Spec.Tolerations) {
+			return response, nil
+		}
+	}
+
+	violation := s.placementViolation(request.Namespace, &pod)
+	if violation == "" {
+		return response, nil
+	}
+
+	if s.config.ValidationWarnOnly || s.auditOnly(request.Namespace) {
+		slog.Info(fmt.Sprintf("Admitting pod violating its placement. %s", violation))
+		response.Warnings = append(response.Warnings, fmt.Sprintf("spot-tolerator: %s", violation))
+		return response, nil
+	}
+
+	slog.Info(fmt.Sprintf("Rejecting pod. %s", violation))
+	response.Allowed = false
+	response.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusForbidden,
+		Reason:  metav1.StatusReasonForbidden,
+		Message: violation,
+	}
+	return response, nil
+}
+
+// placementViolation describes why the pod may not run in its namespace or returns an empty
+// string if it may. Only the mode annotation of the pod or its owners opts out of spot nodes,
+// downgrades of the webhook are overridden by the placement on mutation.
+func (s *Server) placementViolation(namespace string, pod *corev1.Pod) string {
+	placement := s.getPlacement(namespace)
+	name := pod.Name
+	if name == "" {
+		name = pod.GenerateName
+	}
+
+	switch placement {
+	case policy.PlacementSpotOnly:
+		if mode, source, declared := declaredMode(pod, s.getOwners(pod)); declared && mode == policy.ModeSkip {
+			return fmt.Sprintf("pod %s/%s opts out of spot nodes (%s), but namespace %s only allows pods on spot nodes (%s: %s)",
+				namespace, name, source, namespace, policy.PlacementAnnotation, placement)
+		}
+		if !toleratesSpot(pod) {
+			return fmt.Sprintf("pod %s/%s does not tolerate spot nodes (%s), but namespace %s only allows pods on spot nodes (%s: %s)",
+				namespace, name, spotTaint().ToString(), namespace, policy.PlacementAnnotation, placement)
+		}
+	case policy.PlacementNeverSpot:
+		if toleratesSpotExplicitly(pod) {
+			return fmt.Sprintf("pod %s/%s tolerates spot nodes (%s), but namespace %s does not allow pods on spot nodes (%s: %s)",
+				namespace, name, spotTaint().ToString(), namespace, policy.PlacementAnnotation, placement)
+		}
+	}
+	return ""
+}
+
+// applyPlacement adjusts the decision to the placement of the namespace, so that the webhook
+// does not mutate pods into violating it. Pods in never-spot namespaces are skipped. Pods in
+// spot-only namespaces require spot nodes, unless their owners or the pod declare the mode,
+// which then wins over the spot ratio and the downgrades of the webhook.
+func (s *Server) applyPlacement(namespace string, pod *corev1.Pod, owners []k8sClient.Owner, decision policy.Decision) policy.Decision {
+	switch s.getPlacement(namespace) {
+	case policy.PlacementNeverSpot:
+		return policy.Decision{Mode: policy.ModeSkip, Source: policy.SourceNamespace,
+			Reason: fmt.Sprintf("namespace %s is %s", namespace, policy.PlacementNeverSpot), Warnings: decision.Warnings}
+	case policy.PlacementSpotOnly:
+		mode, _, declared := declaredMode(pod, owners)
+		if !declared {
+			mode = policy.ModeRequire
+		}
+		if mode == policy.ModeSkip || decision.Mode == mode {
+			return decision
+		}
+		decision.Mode = mode
+		decision.Reason = fmt.Sprintf("%s, namespace %s is %s", decision.Reason, namespace, policy.PlacementSpotOnly)
+	}
+	return decision
+}
+
+// declaredMode returns the mode annotation of the pod or, if the pod has none, of its top-level
+// owner declaring one, together with where the mode is declared.
+func declaredMode(pod *corev1.Pod, owners []k8sClient.Owner) (policy.Mode, string, bool) {
+	if mode, found, err := policy.ModeFromObject(pod); err == nil && found {
+		return mode, "pod annotation", true
+	}
+	for i := len(owners) - 1; i >= 0; i-- {
+		if mode, found, err := policy.ModeFromObject(owners[i].Object); err == nil && found {
+			return mode, fmt.Sprintf("%s %s", owners[i].Kind, owners[i].Object.GetName()), true
+		}
+	}
+	return "", "", false
+}
+
+func (s *Server) getPlacement(namespace string) policy.Placement {
+	ns := s.getNamespace(namespace)
+	if ns == nil {
+		return ""
+	}
+
+	placement, _, err := policy.PlacementFromObject(ns)
+	if err != nil {
+		slog.Warn(fmt.Sprintf("Ignoring placement of namespace %s. %v", namespace, err))
+	}
+	return placement
+}
+
+// toleratesSpot reports whether the pod tolerates the taint of AKS spot nodes.
+func toleratesSpot(pod *corev1.Pod) bool {
+	taint := spotTaint()
+	for i := range pod.Spec.Tolerations {
+		if pod.Spec.Tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+// toleratesSpotExplicitly reports whether the pod tolerates the taint of AKS spot nodes by a
+// toleration for its key. Blanket tolerations without key, e.g. of DaemonSets, are no spot
+// placement.
+func toleratesSpotExplicitly(pod *corev1.Pod) bool {
+	taint := spotTaint()
+	for i := range pod.Spec.Tolerations {
+		if pod.Spec.Tolerations[i].Key == taint.Key && pod.Spec.Tolerations[i].ToleratesTaint(taint) {
+			return true
+		}
+	}
+	return false
+}
+
+func spotTaint() *corev1.Taint {
+	return &corev1.Taint{Key: config.SpotNodeLabelKey, Value: config.SpotNodeLabelValue, Effect: corev1.TaintEffectNoSchedule}
+}
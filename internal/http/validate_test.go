@@ -0,0 +1,211 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var _ = Describe("placement validation", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	spotToleration := corev1.Toleration{Key: config.SpotNodeLabelKey, Operator: corev1.TolerationOpEqual, Value: config.SpotNodeLabelValue, Effect: corev1.TaintEffectNoSchedule}
+
+	spotDeployment := func(name string, annotations map[string]string) []runtime.Object {
+		objects := deploymentObjects(name, 3)
+		for _, obj := range objects {
+			obj.(metav1.Object).SetNamespace("spot")
+		}
+		objects[0].(metav1.Object).SetAnnotations(annotations)
+		return objects
+	}
+	podOf := func(deployment string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: deployment + "-1-a", Namespace: "spot", OwnerReferences: controllerRef("apps/v1", "ReplicaSet", deployment+"-1")},
+			Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{spotToleration}}}
+	}
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		objects := []runtime.Object{
+			namespaceWithMode("spot", nil, map[string]string{policy.PlacementAnnotation: "spot-only"}),
+			namespaceWithMode("on-demand", nil, map[string]string{policy.PlacementAnnotation: "never-spot"}),
+			namespaceWithMode("audited", nil, map[string]string{policy.PlacementAnnotation: "never-spot", policy.AuditAnnotation: "true"}),
+			namespaceWithMode("plain", nil, nil),
+		}
+		objects = append(objects, spotDeployment("ratio", map[string]string{policy.SpotRatioAnnotation: "0"})...)
+		objects = append(objects, spotDeployment("opted-out", map[string]string{policy.ModeAnnotation: "skip"})...)
+		objects = append(objects, spotDeployment("preferring", map[string]string{policy.ModeAnnotation: "prefer"})...)
+		server = NewServer(cfg, newTestCache(objects...))
+	})
+
+	validatePod := func(namespace string, pod *corev1.Pod) *admissionv1.AdmissionResponse {
+		response, err := server.validate(admissionRequestFor(namespace, pod))
+		Expect(err).NotTo(HaveOccurred())
+		return response
+	}
+
+	It("should reject pods opting out of spot nodes in spot-only namespaces", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Annotations: map[string]string{policy.ModeAnnotation: "skip"}},
+			Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{spotToleration}}}
+		response := validatePod("spot", pod)
+
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Code).To(BeEquivalentTo(http.StatusForbidden))
+		Expect(response.Result.Message).To(Equal("pod spot/web opts out of spot nodes (pod annotation), but namespace spot only allows pods on spot nodes (spot-tolerator.stein.solutions/placement: spot-only)"))
+	})
+
+	It("should only treat the mode annotation of the workload as opt-out in spot-only namespaces", func() {
+		response := validatePod("spot", podOf("opted-out"))
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(HavePrefix("pod spot/opted-out-1-a opts out of spot nodes (Deployment opted-out)"))
+
+		Expect(validatePod("spot", podOf("ratio")).Allowed).To(BeTrue())
+	})
+
+	It("should reject pods not tolerating spot nodes in spot-only namespaces", func() {
+		response := validatePod("spot", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(Equal("pod spot/web does not tolerate spot nodes (kubernetes.azure.com/scalesetpriority=spot:NoSchedule), but namespace spot only allows pods on spot nodes (spot-tolerator.stein.solutions/placement: spot-only)"))
+	})
+
+	It("should admit pods tolerating spot nodes in spot-only namespaces", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}, Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{spotToleration}}}
+
+		Expect(validatePod("spot", pod).Allowed).To(BeTrue())
+	})
+
+	It("should reject pods tolerating spot nodes in never-spot namespaces", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db"}, Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{
+			{Key: config.SpotNodeLabelKey, Operator: corev1.TolerationOpExists}}}}
+		response := validatePod("on-demand", pod)
+
+		Expect(response.Allowed).To(BeFalse())
+		Expect(response.Result.Message).To(Equal("pod on-demand/db tolerates spot nodes (kubernetes.azure.com/scalesetpriority=spot:NoSchedule), but namespace on-demand does not allow pods on spot nodes (spot-tolerator.stein.solutions/placement: never-spot)"))
+
+		Expect(validatePod("on-demand", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db"}}).Allowed).To(BeTrue())
+	})
+
+	It("should admit pods with blanket tolerations in never-spot namespaces", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent"}, Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}}}}
+
+		Expect(validatePod("on-demand", pod).Allowed).To(BeTrue())
+	})
+
+	It("should only warn if configured or if the namespace is audited", func() {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db"}, Spec: corev1.PodSpec{Tolerations: []corev1.Toleration{spotToleration}}}
+
+		response := validatePod("audited", pod)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(HaveLen(1))
+		Expect(response.Warnings[0]).To(HavePrefix("spot-tolerator: pod audited/db tolerates spot nodes"))
+
+		cfg.ValidationWarnOnly = true
+		response = validatePod("on-demand", pod)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Warnings).To(HaveLen(1))
+	})
+
+	It("should admit pods in namespaces without placement", func() {
+		Expect(validatePod("plain", &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}}).Allowed).To(BeTrue())
+	})
+
+	It("should only validate updates changing the tolerations", func() {
+		oldPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db"}}
+		pod := oldPod.DeepCopy()
+		pod.Labels = map[string]string{"app": "db"}
+		request := updateRequestFor(oldPod, pod)
+		request.Namespace = "spot"
+
+		response, err := server.validate(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Allowed).To(BeTrue())
+
+		pod.Spec.Tolerations = []corev1.Toleration{spotToleration}
+		request = updateRequestFor(oldPod, pod)
+		request.Namespace = "on-demand"
+
+		response, err = server.validate(request)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(response.Allowed).To(BeFalse())
+	})
+
+	It("should respond with the status over http", func() {
+		raw, err := json.Marshal(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web"}})
+		Expect(err).NotTo(HaveOccurred())
+		review, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request: &admissionv1.AdmissionRequest{
+				UID:       "12345",
+				Operation: admissionv1.Create,
+				Namespace: "spot",
+				Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+				Object:    runtime.RawExtension{Raw: raw},
+			},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.ServeValidate).ServeHTTP(rr, httptest.NewRequest("POST", "/validate", bytes.NewReader(review)))
+		Expect(rr.Code).To(Equal(200))
+
+		response := admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.Response.UID).To(BeEquivalentTo("12345"))
+		Expect(response.Response.Allowed).To(BeFalse())
+		Expect(response.Response.Result.Message).To(ContainSubstring("does not tolerate spot nodes"))
+	})
+
+	Context("mutation", func() {
+		It("should require spot nodes in spot-only namespaces", func() {
+			decision := decideFor(server, "spot", &corev1.Pod{})
+
+			Expect(decision.Mode).To(Equal(policy.ModeRequire))
+			Expect(decision.Reason).To(Equal("default mode, namespace spot is spot-only"))
+		})
+
+		It("should keep the mode declared by the pod in spot-only namespaces", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{policy.ModeAnnotation: "skip"}}}
+
+			Expect(decideFor(server, "spot", pod).Mode).To(Equal(policy.ModeSkip))
+		})
+
+		It("should override the spot ratio and downgrades in spot-only namespaces", func() {
+			decision := decideFor(server, "spot", podOf("ratio"))
+			Expect(decision.Mode).To(Equal(policy.ModeRequire))
+			Expect(decision.Reason).To(HaveSuffix(", namespace spot is spot-only"))
+
+			cfg.MinReplicasForSpot = 5
+			Expect(decideFor(server, "spot", podOf("preferring")).Mode).To(Equal(policy.ModePrefer))
+			Expect(decideFor(server, "spot", podOf("opted-out")).Mode).To(Equal(policy.ModeSkip))
+		})
+
+		It("should not mutate pods in never-spot namespaces", func() {
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{policy.ModeAnnotation: "require"}}}
+			decision := decideFor(server, "on-demand", pod)
+
+			Expect(decision.Mode).To(Equal(policy.ModeSkip))
+			Expect(decision.Reason).To(Equal("namespace on-demand is never-spot"))
+		})
+
+		It("should keep the warnings of the decision in never-spot namespaces", func() {
+			decision := server.applyPlacement("on-demand", &corev1.Pod{}, nil, policy.Decision{Mode: policy.ModePrefer, Warnings: []string{"spot-tolerator: warning"}})
+
+			Expect(decision.Mode).To(Equal(policy.ModeSkip))
+			Expect(decision.Warnings).To(Equal([]string{"spot-tolerator: warning"}))
+		})
+	})
+})
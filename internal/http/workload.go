@@ -0,0 +1,164 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/k8sClient"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ServeWorkloads mutates the pod templates of workloads instead of their pods, so that the
+// injected tolerations and affinity are visible on the workload.
+func (s *Server) ServeWorkloads(w http.ResponseWriter, r *http.Request) {
+	serveAdmission(w, r, s.mutateWorkload)
+}
+
+func (s *Server) mutateWorkload(request *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, error) {
+	response := &admissionv1.AdmissionResponse{
+		UID:     request.UID,
+		Allowed: true,
+	}
+
+	if err := s.respond(request, response, s.mutateTemplate(request)); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// workload is a decoded workload with the pod template it creates its pods from.
+type workload struct {
+	kind     string
+	object   metav1.Object
+	template *corev1.PodTemplateSpec
+	// path is the json patch path of the template
+	path string
+}
+
+// decodeWorkload decodes the object of the request. Kinds without pod template are not supported.
+func decodeWorkload(request *admissionv1.AdmissionRequest) (workload, error) {
+	kind := request.Kind.Kind
+	var w workload
+	switch request.Kind.Group + "/" + kind {
+	case "apps/Deployment":
+		deployment := &appsv1.Deployment{}
+		w = workload{kind: kind, object: deployment, template: &deployment.Spec.Template, path: "/spec/template"}
+	case "apps/StatefulSet":
+		statefulSet := &appsv1.StatefulSet{}
+		w = workload{kind: kind, object: statefulSet, template: &statefulSet.Spec.Template, path: "/spec/template"}
+	case "apps/DaemonSet":
+		daemonSet := &appsv1.DaemonSet{}
+		w = workload{kind: kind, object: daemonSet, template: &daemonSet.Spec.Template, path: "/spec/template"}
+	case "apps/ReplicaSet":
+		replicaSet := &appsv1.ReplicaSet{}
+		w = workload{kind: kind, object: replicaSet, template: &replicaSet.Spec.Template, path: "/spec/template"}
+	case "batch/Job":
+		job := &batchv1.Job{}
+		w = workload{kind: kind, object: job, template: &job.Spec.Template, path: "/spec/template"}
+	case "batch/CronJob":
+		cronJob := &batchv1.CronJob{}
+		w = workload{kind: kind, object: cronJob, template: &cronJob.Spec.JobTemplate.Spec.Template, path: "/spec/jobTemplate/spec/template"}
+	default:
+		return workload{}, fmt.Errorf("unsupported workload kind %s", request.Kind)
+	}
+
+	if err := json.Unmarshal(request.Object.Raw, w.object); err != nil {
+		return workload{}, err
+	}
+	if w.object.GetNamespace() == "" {
+		w.object.SetNamespace(request.Namespace)
+	}
+	return w, nil
+}
+
+// mutateTemplate patches the pod template of the workload like the pods created from it.
+// Workloads controlled by another workload (e.g. the ReplicaSets of a Deployment) are left
+// alone, their template is owned by the controller and patching it would make it fight the
+// webhook. The template of a Job is immutable, so Jobs are only mutated on creation.
+func (s *Server) mutateTemplate(request *admissionv1.AdmissionRequest) mutation {
+	if request.Operation != admissionv1.Create && request.Operation != admissionv1.Update {
+		return mutation{}
+	}
+
+	w, err := decodeWorkload(request)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not deserialize workload %s/%s: %v", request.Namespace, request.Name, err))
+		return mutation{}
+	}
+	if ref := metav1.GetControllerOf(w.object); ref != nil {
+		slog.Debug(fmt.Sprintf("Not mutating %s %s/%s controlled by %s %s", w.kind, request.Namespace, w.object.GetName(), ref.Kind, ref.Name))
+		return mutation{}
+	}
+	if w.kind == "Job" && request.Operation == admissionv1.Update {
+		return mutation{}
+	}
+
+	// the template is treated like a pod of the workload
+	pod := &corev1.Pod{ObjectMeta: *w.template.ObjectMeta.DeepCopy(), Spec: *w.template.Spec.DeepCopy()}
+	pod.Namespace = request.Namespace
+	owners := []k8sClient.Owner{{Kind: w.kind, Object: w.object}}
+
+	decision := s.decideTemplate(request.Namespace, pod, owners, request.UserInfo)
+	slog.Debug(fmt.Sprintf("Template of %s %s/%s is admitted with mode %s (%s)", w.kind, request.Namespace, w.object.GetName(), decision.Mode, decision.Reason))
+	if decision.Mode == policy.ModeSkip {
+		return mutation{decision: decision}
+	}
+
+	patches, decision := s.podPatches(pod, owners, decision)
+	if len(patches) > 0 {
+		patches = append(patches, metadataPatches(&pod.ObjectMeta,
+			map[string]string{policy.DecisionAnnotation: decision.Annotation()},
+			map[string]string{policy.MutatedLabel: "true"})...)
+	}
+	for i := range patches {
+		patches[i].Path = w.path + patches[i].Path
+	}
+	return mutation{decision: decision, patches: patches}
+}
+
+// decideTemplate determines how the pod template of a workload is mutated. The spot ratio
+// and the minimum of on-demand replicas split the replicas of a workload, which a single
+// template cannot express. The minimum of replicas for spot nodes depends on the replicas,
+// which are scaled without the webhook seeing the workload. Such workloads are left to the
+// admission of their pods, as are
+// templates that would be downgraded for missing spot capacity. Templates only prefer spot
+// nodes, the requirement is added to the pods, so that workloads can fall back.
+func (s *Server) decideTemplate(namespace string, pod *corev1.Pod, owners []k8sClient.Owner, userInfo authenticationv1.UserInfo) policy.Decision {
+	decision := s.declaredDecision(namespace, pod, owners, userInfo)
+
+	if decision.Source != policy.SourcePod && decision.Mode != policy.ModeSkip {
+		if owner, _, found := spotRatioOwner(owners); found {
+			return decision.Override(policy.ModeSkip, policy.SourceOwner,
+				fmt.Sprintf("%s %s declares a spot ratio, applied per pod", owner.Kind, owner.Object.GetName()))
+		}
+		if owner, _, found := k8sClient.ReplicatedOwner(owners); found && (s.config.MinReplicasForSpot > 0 || s.config.MinOnDemandReplicas > 0) {
+			decision.Mode = policy.ModeSkip
+			decision.Reason = fmt.Sprintf("%s, replicas of %s %s are counted per pod", decision.Reason, owner.Kind, owner.Object.GetName())
+			return decision
+		}
+	}
+
+	decision = s.applyRisks(pod, decision)
+	if limited := s.applySpotCapacity(namespace, decision); limited.Mode != decision.Mode {
+		// a downgrade would stay in the template after spot nodes are back
+		limited.Mode = policy.ModeSkip
+		limited.Reason = fmt.Sprintf("%s, left to the admission of the pods", limited.Reason)
+		return limited
+	}
+
+	decision = s.applyPlacement(namespace, pod, owners, decision)
+	if decision.Mode == policy.ModeRequire {
+		// a requirement in the template would survive a fallback to on-demand nodes
+		decision.Mode = policy.ModePrefer
+		decision.Reason = fmt.Sprintf("%s, spot nodes are required by the admission of the pods", decision.Reason)
+	}
+	return decision
+}
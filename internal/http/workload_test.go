@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/policy"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func workloadRequestFor(group string, kind string, workload string) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:       "12345",
+		Operation: admissionv1.Create,
+		Namespace: "plain",
+		Kind:      metav1.GroupVersionKind{Group: group, Version: "v1", Kind: kind},
+		Object:    runtime.RawExtension{Raw: []byte(workload)},
+	}
+}
+
+var _ = Describe("workload templates", func() {
+	var (
+		cfg    *config.Config
+		server *Server
+	)
+
+	BeforeEach(func() {
+		cfg = config.NewConfig()
+		server = NewServer(cfg, newTestCache(namespaceWithMode("plain", nil, nil)))
+	})
+
+	It("should patch the pod template of a Deployment", func() {
+		request := workloadRequestFor("apps", "Deployment", `{"metadata": {"name": "web"}, "spec": {"template": {"spec": {}}}}`)
+		review, err := json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{APIVersion: "admission.k8s.io/v1", Kind: "AdmissionReview"},
+			Request:  request,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.ServeWorkloads).ServeHTTP(rr, httptest.NewRequest("POST", "/mutate-workloads", bytes.NewReader(review)))
+		Expect(rr.Code).To(Equal(200))
+
+		response := admissionv1.AdmissionReview{}
+		Expect(json.Unmarshal(rr.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.Response.Allowed).To(BeTrue())
+		Expect(string(response.Response.Patch)).To(MatchJSON(`[{
+			"op": "add",
+			"path": "/spec/template/spec/tolerations",
+			"value": [{"key": "kubernetes.azure.com/scalesetpriority", "operator": "Equal", "value": "spot", "effect": "NoSchedule"}]
+		}, {
+			"op": "add",
+			"path": "/spec/template/metadata/annotations",
+			"value": {"spot-tolerator.stein.solutions/decision": "{\"mode\":\"tolerate\",\"reason\":\"default mode\"}"}
+		}, {
+			"op": "add",
+			"path": "/spec/template/metadata/labels",
+			"value": {"spot-tolerator.stein.solutions/mutated": "true"}
+		}]`))
+	})
+
+	It("should patch the job template of a CronJob", func() {
+		result := server.mutateTemplate(workloadRequestFor("batch", "CronJob",
+			`{"metadata": {"name": "nightly"}, "spec": {"jobTemplate": {"spec": {"template": {"spec": {}}}}}}`))
+
+		Expect(result.patches).NotTo(BeEmpty())
+		Expect(result.patches[0].Path).To(Equal("/spec/jobTemplate/spec/template/spec/tolerations"))
+	})
+
+	It("should use the kind default and the annotation of the workload", func() {
+		cfg.KindDefaults = map[string]policy.Mode{"StatefulSet": policy.ModeSkip}
+		Expect(server.mutateTemplate(workloadRequestFor("apps", "StatefulSet",
+			`{"metadata": {"name": "db"}, "spec": {"template": {"spec": {}}}}`)).patches).To(BeEmpty())
+
+		result := server.mutateTemplate(workloadRequestFor("apps", "StatefulSet",
+			`{"metadata": {"name": "db", "annotations": {"spot-tolerator.stein.solutions/mode": "prefer"}}, "spec": {"template": {"spec": {}}}}`))
+		Expect(result.decision.Mode).To(Equal(policy.ModePrefer))
+		Expect(result.decision.Source).To(Equal(policy.SourceOwner))
+	})
+
+	It("should leave the spot requirement to the admission of the pods", func() {
+		result := server.mutateTemplate(workloadRequestFor("apps", "Deployment",
+			`{"metadata": {"name": "web", "annotations": {"spot-tolerator.stein.solutions/mode": "require"}}, "spec": {"template": {"spec": {}}}}`))
+
+		Expect(result.decision.Mode).To(Equal(policy.ModePrefer))
+		Expect(result.patches).To(ContainElement(HaveField("Path", "/spec/template/spec/affinity")))
+		affinity := result.patches[1].Value.(*corev1.Affinity)
+		Expect(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution).To(BeNil())
+		Expect(affinity.NodeAffinity.PreferredDuringSchedulingIgnoredDuringExecution).To(HaveLen(1))
+	})
+
+	It("should not patch workloads controlled by another workload", func() {
+		request := workloadRequestFor("apps", "ReplicaSet", `{
+			"metadata": {"name": "web-1", "ownerReferences": [{"apiVersion": "apps/v1", "kind": "Deployment", "name": "web", "uid": "web", "controller": true}]},
+			"spec": {"template": {"spec": {}}}
+		}`)
+
+		Expect(server.mutateTemplate(request).patches).To(BeEmpty())
+	})
+
+	It("should not patch the template of updated Jobs", func() {
+		request := workloadRequestFor("batch", "Job", `{"metadata": {"name": "migrate"}, "spec": {"template": {"spec": {}}}}`)
+		Expect(server.mutateTemplate(request).patches).NotTo(BeEmpty())
+
+		request.Operation = admissionv1.Update
+		Expect(server.mutateTemplate(request).patches).To(BeEmpty())
+	})
+
+	It("should leave workloads with a spot ratio to the admission of their pods", func() {
+		result := server.mutateTemplate(workloadRequestFor("apps", "Deployment",
+			`{"metadata": {"name": "web", "annotations": {"spot-tolerator.stein.solutions/spot-ratio": "50"}}, "spec": {"template": {"spec": {}}}}`))
+
+		Expect(result.patches).To(BeEmpty())
+		Expect(result.decision.Mode).To(Equal(policy.ModeSkip))
+	})
+
+	It("should leave the minimum of replicas for spot nodes to the admission of the pods", func() {
+		cfg.MinReplicasForSpot = 2
+
+		Expect(server.mutateTemplate(workloadRequestFor("apps", "Deployment",
+			`{"metadata": {"name": "web"}, "spec": {"replicas": 1, "template": {"spec": {}}}}`)).patches).To(BeEmpty())
+		Expect(server.mutateTemplate(workloadRequestFor("apps", "Deployment",
+			`{"metadata": {"name": "web"}, "spec": {"replicas": 3, "template": {"spec": {}}}}`)).patches).To(BeEmpty())
+		Expect(server.mutateTemplate(workloadRequestFor("batch", "Job",
+			`{"metadata": {"name": "migrate"}, "spec": {"template": {"spec": {}}}}`)).patches).NotTo(BeEmpty())
+	})
+
+	It("should ignore other kinds", func() {
+		Expect(server.mutateTemplate(workloadRequestFor("", "Service", `{"metadata": {"name": "web"}}`)).patches).To(BeEmpty())
+	})
+})
@@ -0,0 +1,178 @@
+package k8sClient
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Cache serves cluster objects needed during admission from shared informers,
+// so that the webhook does not need to call the api server per request.
+type Cache struct {
+	factory      informers.SharedInformerFactory
+	namespaces   corelisters.NamespaceLister
+	pods         corelisters.PodLister
+	nodes        corelisters.NodeLister
+	replicaSets  appslisters.ReplicaSetLister
+	deployments  appslisters.DeploymentLister
+	statefulSets appslisters.StatefulSetLister
+	daemonSets   appslisters.DaemonSetLister
+	jobs         batchlisters.JobLister
+	cronJobs     batchlisters.CronJobLister
+	spotPolicies cache.SharedIndexInformer
+	synced       []cache.InformerSynced
+
+	nodePools        []NodePool
+	readySpotNodes   int
+	nodePoolsLock    sync.Mutex
+	nodePoolsChanged atomic.Bool
+}
+
+func NewCache(client K8sClientInterface, resync time.Duration) *Cache {
+	factory := informers.NewSharedInformerFactory(client.Clientset(), resync)
+	namespaceInformer := factory.Core().V1().Namespaces()
+	podInformer := factory.Core().V1().Pods()
+	nodeInformer := factory.Core().V1().Nodes()
+	replicaSetInformer := factory.Apps().V1().ReplicaSets()
+	deploymentInformer := factory.Apps().V1().Deployments()
+	statefulSetInformer := factory.Apps().V1().StatefulSets()
+	daemonSetInformer := factory.Apps().V1().DaemonSets()
+	jobInformer := factory.Batch().V1().Jobs()
+	cronJobInformer := factory.Batch().V1().CronJobs()
+
+	c := &Cache{
+		factory:      factory,
+		namespaces:   namespaceInformer.Lister(),
+		pods:         podInformer.Lister(),
+		nodes:        nodeInformer.Lister(),
+		replicaSets:  replicaSetInformer.Lister(),
+		deployments:  deploymentInformer.Lister(),
+		statefulSets: statefulSetInformer.Lister(),
+		daemonSets:   daemonSetInformer.Lister(),
+		jobs:         jobInformer.Lister(),
+		cronJobs:     cronJobInformer.Lister(),
+		synced: []cache.InformerSynced{
+			namespaceInformer.Informer().HasSynced,
+			podInformer.Informer().HasSynced,
+			nodeInformer.Informer().HasSynced,
+			replicaSetInformer.Informer().HasSynced,
+			deploymentInformer.Informer().HasSynced,
+			statefulSetInformer.Informer().HasSynced,
+			daemonSetInformer.Informer().HasSynced,
+			jobInformer.Informer().HasSynced,
+			cronJobInformer.Informer().HasSynced,
+		},
+	}
+
+	c.nodePoolsChanged.Store(true)
+	nodeChanged := func(interface{}) { c.nodePoolsChanged.Store(true) }
+	if _, err := nodeInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    nodeChanged,
+		UpdateFunc: func(_, obj interface{}) { nodeChanged(obj) },
+		DeleteFunc: nodeChanged,
+	}); err != nil {
+		slog.Error(fmt.Sprintf("Could not watch nodes for node pool discovery. %v", err))
+	}
+
+	if policies := client.SpotPolicies(); policies != nil && spotPoliciesServed(client.Clientset().Discovery()) {
+		spotPolicyInformer := cache.NewSharedIndexInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return policies.List(context.TODO(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return policies.Watch(context.TODO(), options)
+			},
+		}, &v1alpha1.SpotPolicy{}, resync, cache.Indexers{})
+		factory.InformerFor(&v1alpha1.SpotPolicy{}, func(kubernetes.Interface, time.Duration) cache.SharedIndexInformer {
+			return spotPolicyInformer
+		})
+		c.spotPolicies = spotPolicyInformer
+		c.synced = append(c.synced, spotPolicyInformer.HasSynced)
+	}
+
+	return c
+}
+
+// spotPoliciesServed reports whether the api server serves SpotPolicies. Without the CRD the
+// informer would never sync and block the start of the webhook.
+func spotPoliciesServed(discovery discovery.DiscoveryInterface) bool {
+	resources, err := discovery.ServerResourcesForGroupVersion(v1alpha1.SchemeGroupVersion.String())
+	if err == nil && slices.ContainsFunc(resources.APIResources, func(resource metav1.APIResource) bool {
+		return resource.Name == v1alpha1.SpotPolicyResource.Resource
+	}) {
+		return true
+	}
+	slog.Warn(fmt.Sprintf("SpotPolicies are not served, ignoring them until the next restart. Apply the SpotPolicy CRD of the helm chart. %v", err))
+	return false
+}
+
+// Start starts the informers and blocks until their caches are filled.
+func (c *Cache) Start(stopCh <-chan struct{}) error {
+	slog.Info("Starting informer cache")
+	c.factory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, c.synced...) {
+		return fmt.Errorf("failed to sync informer cache")
+	}
+	slog.Info("Informer cache synced")
+	return nil
+}
+
+func (c *Cache) Namespaces() corelisters.NamespaceLister {
+	return c.namespaces
+}
+
+func (c *Cache) Pods() corelisters.PodLister {
+	return c.pods
+}
+
+func (c *Cache) Nodes() corelisters.NodeLister {
+	return c.nodes
+}
+
+// SpotPolicies returns all SpotPolicies ordered by priority and name.
+func (c *Cache) SpotPolicies() []*v1alpha1.SpotPolicy {
+	if c.spotPolicies == nil {
+		return nil
+	}
+
+	policies := []*v1alpha1.SpotPolicy{}
+	for _, obj := range c.spotPolicies.GetStore().List() {
+		if policy, ok := obj.(*v1alpha1.SpotPolicy); ok {
+			policies = append(policies, policy)
+		}
+	}
+	slices.SortFunc(policies, func(a, b *v1alpha1.SpotPolicy) int {
+		if a.Spec.Priority != b.Spec.Priority {
+			return cmp.Compare(a.Spec.Priority, b.Spec.Priority)
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return policies
+}
+
+// AddSpotPolicyHandler registers a handler that is notified about all SpotPolicies and their
+// changes. It does nothing if SpotPolicies are not served.
+func (c *Cache) AddSpotPolicyHandler(handler cache.ResourceEventHandler) error {
+	if c.spotPolicies == nil {
+		return nil
+	}
+	_, err := c.spotPolicies.AddEventHandler(handler)
+	return err
+}
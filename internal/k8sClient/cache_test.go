@@ -0,0 +1,49 @@
+package k8sClient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type mockK8sClient struct {
+	clientset    kubernetes.Interface
+	spotPolicies SpotPolicyInterface
+}
+
+func (m *mockK8sClient) Clientset() kubernetes.Interface {
+	return m.clientset
+}
+
+func (m *mockK8sClient) SpotPolicies() SpotPolicyInterface {
+	return m.spotPolicies
+}
+
+func TestCache_ServesNamespaces(t *testing.T) {
+	t.Parallel()
+
+	client := &mockK8sClient{clientset: fake.NewSimpleClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "existing"}})}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	cache := NewCache(client, 0)
+	assert.NoError(t, cache.Start(stopCh))
+
+	ns, err := cache.Namespaces().Get("existing")
+	assert.NoError(t, err)
+	assert.Equal(t, "existing", ns.Name)
+
+	_, err = client.Clientset().CoreV1().Namespaces().Create(context.TODO(), &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "new"}}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		_, err := cache.Namespaces().Get("new")
+		return err == nil
+	}, 5*time.Second, 100*time.Millisecond)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	localConfig "github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -12,10 +13,12 @@ import (
 
 type K8sClientInterface interface {
 	Clientset() kubernetes.Interface
+	SpotPolicies() SpotPolicyInterface
 }
 
 type K8sClient struct {
-	_clientset kubernetes.Interface
+	_clientset    kubernetes.Interface
+	_spotPolicies SpotPolicyInterface
 }
 
 type ConfigProvider interface {
@@ -33,6 +36,10 @@ func (k *K8sClient) Clientset() kubernetes.Interface {
 	return k._clientset
 }
 
+func (k *K8sClient) SpotPolicies() SpotPolicyInterface {
+	return k._spotPolicies
+}
+
 func NewK8sClientDefault() K8sClientInterface {
 	configProviderImpl := &DefaultConfigProvider{}
 	return NewK8sClient(configProviderImpl)
@@ -60,8 +67,14 @@ func NewK8sClient(provider ConfigProvider) K8sClientInterface {
 		return nil
 	}
 
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil
+	}
+
 	k8sClient := &K8sClient{
-		_clientset: clientset,
+		_clientset:    clientset,
+		_spotPolicies: NewSpotPolicyClient(dynamicClient),
 	}
 
 	return k8sClient
@@ -0,0 +1,147 @@
+package k8sClient
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
+	localConfig "github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// NodePool is a group of nodes sharing the agentpool label.
+type NodePool struct {
+	Name string
+	Spot bool
+	// Taints are the taints carried by all nodes of the pool. Taints that are set on single
+	// nodes for a while (e.g. by the node lifecycle controller or the cluster autoscaler) are
+	// left out.
+	Taints []corev1.Taint
+}
+
+// transientTaints are prefixes of taints that mark the state of a single node rather than the
+// pool. Tolerating them would defeat their purpose.
+var transientTaints = []string{
+	"node.kubernetes.io/",
+	"node.cloudprovider.kubernetes.io/",
+	"ToBeDeletedByClusterAutoscaler",
+	"DeletionCandidateOfClusterAutoscaler",
+	"spot-tolerator.stein.solutions/",
+}
+
+// NodePools returns the node pools discovered from the nodes in the cache ordered by name. The
+// pools are derived again after nodes changed.
+func (c *Cache) NodePools() ([]NodePool, error) {
+	c.nodePoolsLock.Lock()
+	defer c.nodePoolsLock.Unlock()
+
+	if err := c.refreshNodes(); err != nil {
+		return nil, err
+	}
+	return c.nodePools, nil
+}
+
+// ReadySpotNodes returns the number of spot nodes in the cache that can take new pods.
+func (c *Cache) ReadySpotNodes() (int, error) {
+	c.nodePoolsLock.Lock()
+	defer c.nodePoolsLock.Unlock()
+
+	if err := c.refreshNodes(); err != nil {
+		return 0, err
+	}
+	return c.readySpotNodes, nil
+}
+
+// refreshNodes derives the node pools and the spot capacity again if nodes changed. The
+// caller needs to hold the node pools lock.
+func (c *Cache) refreshNodes() error {
+	if !c.nodePoolsChanged.Swap(false) {
+		return nil
+	}
+
+	nodes, err := c.nodes.List(labels.Everything())
+	if err != nil {
+		c.nodePoolsChanged.Store(true)
+		return fmt.Errorf("could not list nodes: %v", err)
+	}
+	c.nodePools = DiscoverNodePools(nodes)
+	c.readySpotNodes = 0
+	for _, node := range nodes {
+		if IsSpotNode(node) && IsSchedulable(node) {
+			c.readySpotNodes++
+		}
+	}
+	return nil
+}
+
+// SpotNodePools returns the discovered pools of spot nodes.
+func (c *Cache) SpotNodePools() ([]NodePool, error) {
+	pools, err := c.NodePools()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(slices.Clone(pools), func(pool NodePool) bool { return !pool.Spot }), nil
+}
+
+// DiscoverNodePools groups the nodes by their agentpool label. A pool is a spot pool if its
+// nodes carry the spot scalesetpriority label.
+func DiscoverNodePools(nodes []*corev1.Node) []NodePool {
+	pools := map[string]*NodePool{}
+	for _, node := range nodes {
+		name := node.Labels[localConfig.AgentPoolLabelKey]
+		taints := slices.DeleteFunc(slices.Clone(node.Spec.Taints), isTransientTaint)
+
+		pool, exists := pools[name]
+		if !exists {
+			pools[name] = &NodePool{
+				Name:   name,
+				Spot:   IsSpotNode(node),
+				Taints: taints,
+			}
+			continue
+		}
+		pool.Taints = slices.DeleteFunc(pool.Taints, func(taint corev1.Taint) bool {
+			return !slices.ContainsFunc(taints, func(t corev1.Taint) bool { return t.MatchTaint(&taint) && t.Value == taint.Value })
+		})
+	}
+
+	result := make([]NodePool, 0, len(pools))
+	for _, pool := range pools {
+		slices.SortFunc(pool.Taints, func(a, b corev1.Taint) int {
+			return cmp.Or(cmp.Compare(a.Key, b.Key), cmp.Compare(a.Effect, b.Effect))
+		})
+		result = append(result, *pool)
+	}
+	slices.SortFunc(result, func(a, b NodePool) int { return cmp.Compare(a.Name, b.Name) })
+	return result
+}
+
+// IsSpotNode reports whether the node is an AKS spot node.
+func IsSpotNode(node *corev1.Node) bool {
+	return node.Labels[localConfig.SpotNodeLabelKey] == localConfig.SpotNodeLabelValue
+}
+
+// IsSchedulable reports whether the node is ready and takes new pods: it is neither cordoned
+// nor tainted for being unhealthy, removed by the cluster autoscaler or evicted.
+func IsSchedulable(node *corev1.Node) bool {
+	if node.Spec.Unschedulable || node.DeletionTimestamp != nil {
+		return false
+	}
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect != corev1.TaintEffectPreferNoSchedule && isTransientTaint(taint) {
+			return false
+		}
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func isTransientTaint(taint corev1.Taint) bool {
+	return slices.ContainsFunc(transientTaints, func(prefix string) bool { return strings.HasPrefix(taint.Key, prefix) })
+}
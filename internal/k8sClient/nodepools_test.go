@@ -0,0 +1,116 @@
+package k8sClient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	localConfig "github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func poolNode(name string, pool string, spot bool, taints ...corev1.Taint) *corev1.Node {
+	labels := map[string]string{localConfig.AgentPoolLabelKey: pool}
+	if spot {
+		labels[localConfig.SpotNodeLabelKey] = localConfig.SpotNodeLabelValue
+	}
+	return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}, Spec: corev1.NodeSpec{Taints: taints}}
+}
+
+var (
+	spotTaint = corev1.Taint{Key: localConfig.SpotNodeLabelKey, Value: localConfig.SpotNodeLabelValue, Effect: corev1.TaintEffectNoSchedule}
+	gpuTaint  = corev1.Taint{Key: "sku", Value: "gpu", Effect: corev1.TaintEffectNoSchedule}
+)
+
+func TestDiscoverNodePools(t *testing.T) {
+	t.Parallel()
+
+	pools := DiscoverNodePools([]*corev1.Node{
+		poolNode("system-1", "system", false),
+		poolNode("spotgpu-1", "spotgpu", true, spotTaint, gpuTaint),
+		poolNode("spot-1", "spot", true, spotTaint),
+		poolNode("spotgpu-2", "spotgpu", true, gpuTaint, spotTaint,
+			corev1.Taint{Key: "node.kubernetes.io/unschedulable", Effect: corev1.TaintEffectNoSchedule}),
+	})
+
+	assert.Equal(t, []NodePool{
+		{Name: "spot", Spot: true, Taints: []corev1.Taint{spotTaint}},
+		{Name: "spotgpu", Spot: true, Taints: []corev1.Taint{spotTaint, gpuTaint}},
+		{Name: "system", Spot: false},
+	}, pools)
+}
+
+func TestDiscoverNodePools_OnlyKeepsTaintsOfAllNodes(t *testing.T) {
+	t.Parallel()
+
+	pools := DiscoverNodePools([]*corev1.Node{
+		poolNode("spot-1", "spot", true, spotTaint, corev1.Taint{Key: "maintenance", Effect: corev1.TaintEffectNoExecute}),
+		poolNode("spot-2", "spot", true, spotTaint),
+		poolNode("spot-3", "spot", true, corev1.Taint{Key: spotTaint.Key, Value: "other", Effect: spotTaint.Effect}, spotTaint),
+	})
+
+	assert.Equal(t, []corev1.Taint{spotTaint}, pools[0].Taints)
+}
+
+func TestCache_DiscoversNewSpotPools(t *testing.T) {
+	t.Parallel()
+
+	client := &mockK8sClient{clientset: fake.NewSimpleClientset(poolNode("system-1", "system", false), poolNode("spot-1", "spot", true, spotTaint))}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	cache := NewCache(client, 0)
+	assert.NoError(t, cache.Start(stopCh))
+
+	pools, err := cache.SpotNodePools()
+	assert.NoError(t, err)
+	assert.Equal(t, []NodePool{{Name: "spot", Spot: true, Taints: []corev1.Taint{spotTaint}}}, pools)
+
+	_, err = client.Clientset().CoreV1().Nodes().Create(context.TODO(), poolNode("spotgpu-1", "spotgpu", true, spotTaint, gpuTaint), metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		pools, err := cache.SpotNodePools()
+		return err == nil && len(pools) == 2
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestIsSchedulable(t *testing.T) {
+	t.Parallel()
+
+	ready := poolNode("spot-1", "spot", true, spotTaint)
+	ready.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	assert.True(t, IsSchedulable(ready))
+
+	notReady := ready.DeepCopy()
+	notReady.Status.Conditions[0].Status = corev1.ConditionFalse
+	assert.False(t, IsSchedulable(notReady))
+
+	cordoned := ready.DeepCopy()
+	cordoned.Spec.Unschedulable = true
+	assert.False(t, IsSchedulable(cordoned))
+
+	scaledDown := ready.DeepCopy()
+	scaledDown.Spec.Taints = append(scaledDown.Spec.Taints, corev1.Taint{Key: "ToBeDeletedByClusterAutoscaler", Effect: corev1.TaintEffectNoSchedule})
+	assert.False(t, IsSchedulable(scaledDown))
+
+	assert.False(t, IsSchedulable(poolNode("spot-2", "spot", true)))
+}
+
+func TestCache_CountsReadySpotNodes(t *testing.T) {
+	t.Parallel()
+
+	ready := poolNode("spot-1", "spot", true, spotTaint)
+	ready.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}}
+	system := poolNode("system-1", "system", false)
+	system.Status.Conditions = ready.Status.Conditions
+
+	cache := startCache(t, ready, system, poolNode("spot-2", "spot", true, spotTaint))
+
+	count, err := cache.ReadySpotNodes()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
@@ -0,0 +1,213 @@
+package k8sClient
+
+import (
+	"fmt"
+	"log/slog"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+const maxOwnerDepth = 5
+
+type Owner struct {
+	Kind   string
+	Object metav1.Object
+}
+
+// Owners follows the controller references of the object and returns the chain of
+// owners starting with the direct controller. The last element is the top-level owner.
+// Owners that are not in the cache end the chain.
+func (c *Cache) Owners(obj metav1.Object) []Owner {
+	owners := []Owner{}
+	current := obj
+	for i := 0; i < maxOwnerDepth; i++ {
+		ref := metav1.GetControllerOf(current)
+		if ref == nil {
+			break
+		}
+
+		owner, err := c.getOwner(obj.GetNamespace(), ref)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Could not resolve owner %s %s/%s. %v", ref.Kind, obj.GetNamespace(), ref.Name, err))
+			break
+		}
+		if owner.GetUID() != ref.UID {
+			slog.Debug(fmt.Sprintf("Owner %s %s/%s has a different uid than referenced", ref.Kind, obj.GetNamespace(), ref.Name))
+			break
+		}
+
+		owners = append(owners, Owner{Kind: ref.Kind, Object: owner})
+		current = owner
+	}
+	return owners
+}
+
+func (c *Cache) getOwner(namespace string, ref *metav1.OwnerReference) (metav1.Object, error) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	switch gv.WithKind(ref.Kind).GroupKind() {
+	case schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}:
+		return c.replicaSets.ReplicaSets(namespace).Get(ref.Name)
+	case schema.GroupKind{Group: "apps", Kind: "Deployment"}:
+		return c.deployments.Deployments(namespace).Get(ref.Name)
+	case schema.GroupKind{Group: "apps", Kind: "StatefulSet"}:
+		return c.statefulSets.StatefulSets(namespace).Get(ref.Name)
+	case schema.GroupKind{Group: "apps", Kind: "DaemonSet"}:
+		return c.daemonSets.DaemonSets(namespace).Get(ref.Name)
+	case schema.GroupKind{Group: "batch", Kind: "Job"}:
+		return c.jobs.Jobs(namespace).Get(ref.Name)
+	case schema.GroupKind{Group: "batch", Kind: "CronJob"}:
+		return c.cronJobs.CronJobs(namespace).Get(ref.Name)
+	default:
+		return nil, fmt.Errorf("unsupported owner kind %s", ref.Kind)
+	}
+}
+
+// Replicas returns the desired number of replicas for owners that manage replicas.
+func (o Owner) Replicas() (int32, bool) {
+	var replicas *int32
+	switch owner := o.Object.(type) {
+	case *appsv1.Deployment:
+		replicas = owner.Spec.Replicas
+	case *appsv1.StatefulSet:
+		replicas = owner.Spec.Replicas
+	case *appsv1.ReplicaSet:
+		replicas = owner.Spec.Replicas
+	default:
+		return 0, false
+	}
+
+	if replicas == nil {
+		return 1, true
+	}
+	return *replicas, true
+}
+
+// LabelSelector returns the label selector the owner uses to select its pods.
+func (o Owner) LabelSelector() *metav1.LabelSelector {
+	switch owner := o.Object.(type) {
+	case *appsv1.Deployment:
+		return owner.Spec.Selector
+	case *appsv1.StatefulSet:
+		return owner.Spec.Selector
+	case *appsv1.ReplicaSet:
+		return owner.Spec.Selector
+	case *appsv1.DaemonSet:
+		return owner.Spec.Selector
+	case *batchv1.Job:
+		return owner.Spec.Selector
+	default:
+		return nil
+	}
+}
+
+func (o Owner) Selector() (labels.Selector, error) {
+	selector := o.LabelSelector()
+	if selector == nil {
+		return nil, fmt.Errorf("%s %s has no pod selector", o.Kind, o.Object.GetName())
+	}
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// Siblings returns the pods in the cache that are selected by the owner.
+func (c *Cache) Siblings(owner Owner) ([]*corev1.Pod, error) {
+	selector, err := owner.Selector()
+	if err != nil {
+		return nil, err
+	}
+	return c.pods.Pods(owner.Object.GetNamespace()).List(selector)
+}
+
+// ReplicatedOwner returns the top-most of the owners that manages replicas and its replicas.
+func ReplicatedOwner(owners []Owner) (Owner, int32, bool) {
+	for i := len(owners) - 1; i >= 0; i-- {
+		if replicas, ok := owners[i].Replicas(); ok {
+			return owners[i], replicas, true
+		}
+	}
+	return Owner{}, 0, false
+}
+
+// OnDemandReplicas counts the pods of the owner that are scheduled on on-demand nodes and are
+// neither terminating nor completed.
+func (c *Cache) OnDemandReplicas(owner Owner) (int, error) {
+	siblings, err := c.Siblings(owner)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, sibling := range siblings {
+		if sibling.DeletionTimestamp != nil || sibling.Spec.NodeName == "" ||
+			sibling.Status.Phase == corev1.PodSucceeded || sibling.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if node, err := c.nodes.Get(sibling.Spec.NodeName); err == nil && !IsSpotNode(node) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Workloads returns all workloads in the cache that can own pods.
+func (c *Cache) Workloads() ([]Owner, error) {
+	workloads := []Owner{}
+
+	deployments, err := c.deployments.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, deployment := range deployments {
+		workloads = append(workloads, Owner{Kind: "Deployment", Object: deployment})
+	}
+
+	statefulSets, err := c.statefulSets.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, statefulSet := range statefulSets {
+		workloads = append(workloads, Owner{Kind: "StatefulSet", Object: statefulSet})
+	}
+
+	daemonSets, err := c.daemonSets.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, daemonSet := range daemonSets {
+		workloads = append(workloads, Owner{Kind: "DaemonSet", Object: daemonSet})
+	}
+
+	replicaSets, err := c.replicaSets.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, replicaSet := range replicaSets {
+		workloads = append(workloads, Owner{Kind: "ReplicaSet", Object: replicaSet})
+	}
+
+	jobs, err := c.jobs.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, job := range jobs {
+		workloads = append(workloads, Owner{Kind: "Job", Object: job})
+	}
+
+	cronJobs, err := c.cronJobs.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, cronJob := range cronJobs {
+		workloads = append(workloads, Owner{Kind: "CronJob", Object: cronJob})
+	}
+
+	return workloads, nil
+}
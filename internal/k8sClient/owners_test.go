@@ -0,0 +1,158 @@
+package k8sClient
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func controllerRef(apiVersion, kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{APIVersion: apiVersion, Kind: kind, Name: name, UID: types.UID(name), Controller: &controller}}
+}
+
+func startCache(t *testing.T, objects ...runtime.Object) *Cache {
+	stopCh := make(chan struct{})
+	t.Cleanup(func() { close(stopCh) })
+
+	cache := NewCache(&mockK8sClient{clientset: fake.NewSimpleClientset(objects...)}, 0)
+	assert.NoError(t, cache.Start(stopCh))
+	return cache
+}
+
+func TestOwners_DeploymentChain(t *testing.T) {
+	t.Parallel()
+
+	cache := startCache(t,
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", UID: "web"}},
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "web-123", Namespace: "ns", UID: "web-123",
+			OwnerReferences: controllerRef("apps/v1", "Deployment", "web")}},
+	)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-123-abc", Namespace: "ns",
+		OwnerReferences: controllerRef("apps/v1", "ReplicaSet", "web-123")}}
+	owners := cache.Owners(pod)
+
+	assert.Len(t, owners, 2)
+	assert.Equal(t, "ReplicaSet", owners[0].Kind)
+	assert.Equal(t, "web-123", owners[0].Object.GetName())
+	assert.Equal(t, "Deployment", owners[1].Kind)
+	assert.Equal(t, "web", owners[1].Object.GetName())
+}
+
+func TestOwners_CronJobChain(t *testing.T) {
+	t.Parallel()
+
+	cache := startCache(t,
+		&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "ns", UID: "nightly"}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "nightly-1", Namespace: "ns", UID: "nightly-1",
+			OwnerReferences: controllerRef("batch/v1", "CronJob", "nightly")}},
+	)
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "nightly-1-abc", Namespace: "ns",
+		OwnerReferences: controllerRef("batch/v1", "Job", "nightly-1")}}
+	owners := cache.Owners(pod)
+
+	assert.Len(t, owners, 2)
+	assert.Equal(t, "Job", owners[0].Kind)
+	assert.Equal(t, "CronJob", owners[1].Kind)
+}
+
+func TestOwners_StopsAtUnknownOwners(t *testing.T) {
+	t.Parallel()
+
+	cache := startCache(t,
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "ns", UID: "other-uid"}},
+	)
+
+	stale := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "ns",
+		OwnerReferences: controllerRef("apps/v1", "StatefulSet", "db")}}
+	assert.Empty(t, cache.Owners(stale))
+
+	custom := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "rollout-abc", Namespace: "ns",
+		OwnerReferences: controllerRef("argoproj.io/v1alpha1", "Rollout", "rollout")}}
+	assert.Empty(t, cache.Owners(custom))
+
+	bare := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "bare", Namespace: "ns"}}
+	assert.Empty(t, cache.Owners(bare))
+}
+
+func TestOwners_ReplicasAndSiblings(t *testing.T) {
+	t.Parallel()
+
+	replicas := int32(3)
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", UID: "web"},
+		Spec: appsv1.DeploymentSpec{Replicas: &replicas, Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}}
+	cache := startCache(t, deployment,
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-1", Namespace: "ns", Labels: map[string]string{"app": "web"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web-2", Namespace: "other", Labels: map[string]string{"app": "web"}}},
+		&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-1", Namespace: "ns", Labels: map[string]string{"app": "db"}}},
+	)
+	owner := Owner{Kind: "Deployment", Object: deployment}
+
+	count, ok := owner.Replicas()
+	assert.True(t, ok)
+	assert.Equal(t, int32(3), count)
+
+	siblings, err := cache.Siblings(owner)
+	assert.NoError(t, err)
+	assert.Len(t, siblings, 1)
+	assert.Equal(t, "web-1", siblings[0].Name)
+
+	_, ok = Owner{Kind: "CronJob", Object: &batchv1.CronJob{}}.Replicas()
+	assert.False(t, ok)
+}
+
+func TestReplicatedOwnerAndOnDemandReplicas(t *testing.T) {
+	t.Parallel()
+
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", UID: "web"},
+		Spec: appsv1.DeploymentSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}}}
+	pod := func(name string, node string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "ns", Labels: map[string]string{"app": "web"}},
+			Spec: corev1.PodSpec{NodeName: node}, Status: corev1.PodStatus{Phase: phase}}
+	}
+	cache := startCache(t, deployment,
+		poolNode("spot-1", "spot", true), poolNode("system-1", "system", false),
+		pod("web-1", "system-1", corev1.PodRunning),
+		pod("web-2", "spot-1", corev1.PodRunning),
+		pod("web-3", "system-1", corev1.PodSucceeded),
+		pod("web-4", "", corev1.PodPending),
+	)
+
+	owner, replicas, found := ReplicatedOwner([]Owner{{Kind: "Pod", Object: &corev1.Pod{}}, {Kind: "Deployment", Object: deployment}})
+	assert.True(t, found)
+	assert.Equal(t, int32(1), replicas)
+	assert.Equal(t, "web", owner.Object.GetName())
+
+	_, _, found = ReplicatedOwner([]Owner{{Kind: "CronJob", Object: &batchv1.CronJob{}}})
+	assert.False(t, found)
+
+	onDemand, err := cache.OnDemandReplicas(owner)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, onDemand)
+}
+
+func TestWorkloads(t *testing.T) {
+	t.Parallel()
+
+	cache := startCache(t,
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"}},
+		&batchv1.CronJob{ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "ns"}},
+	)
+
+	workloads, err := cache.Workloads()
+	assert.NoError(t, err)
+	assert.Len(t, workloads, 2)
+	assert.Equal(t, "Deployment", workloads[0].Kind)
+	assert.Equal(t, "web", workloads[0].Object.GetName())
+	assert.Equal(t, "CronJob", workloads[1].Kind)
+	assert.Equal(t, "nightly", workloads[1].Object.GetName())
+}
@@ -0,0 +1,127 @@
+package k8sClient
+
+import (
+	"context"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// SpotPolicyInterface is a typed client for the cluster-scoped SpotPolicy resource.
+type SpotPolicyInterface interface {
+	Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.SpotPolicy, error)
+	List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.SpotPolicyList, error)
+	Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error)
+	Create(ctx context.Context, policy *v1alpha1.SpotPolicy, opts metav1.CreateOptions) (*v1alpha1.SpotPolicy, error)
+	Update(ctx context.Context, policy *v1alpha1.SpotPolicy, opts metav1.UpdateOptions) (*v1alpha1.SpotPolicy, error)
+	Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error
+}
+
+type spotPolicies struct {
+	client dynamic.ResourceInterface
+}
+
+func NewSpotPolicyClient(client dynamic.Interface) SpotPolicyInterface {
+	return &spotPolicies{
+		client: client.Resource(v1alpha1.SpotPolicyResource),
+	}
+}
+
+func (c *spotPolicies) Get(ctx context.Context, name string, opts metav1.GetOptions) (*v1alpha1.SpotPolicy, error) {
+	obj, err := c.client.Get(ctx, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	return toSpotPolicy(obj)
+}
+
+func (c *spotPolicies) List(ctx context.Context, opts metav1.ListOptions) (*v1alpha1.SpotPolicyList, error) {
+	list, err := c.client.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &v1alpha1.SpotPolicyList{
+		TypeMeta: metav1.TypeMeta{APIVersion: v1alpha1.SchemeGroupVersion.String(), Kind: "SpotPolicyList"},
+		ListMeta: metav1.ListMeta{ResourceVersion: list.GetResourceVersion(), Continue: list.GetContinue()},
+		Items:    make([]v1alpha1.SpotPolicy, 0, len(list.Items)),
+	}
+	for i := range list.Items {
+		policy, err := toSpotPolicy(&list.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		result.Items = append(result.Items, *policy)
+	}
+	return result, nil
+}
+
+// Watch converts the events of the dynamic client into typed SpotPolicies.
+func (c *spotPolicies) Watch(ctx context.Context, opts metav1.ListOptions) (watch.Interface, error) {
+	watcher, err := c.client.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return watch.Filter(watcher, func(event watch.Event) (watch.Event, bool) {
+		if obj, ok := event.Object.(*unstructured.Unstructured); ok {
+			policy, err := toSpotPolicy(obj)
+			if err != nil {
+				return event, false
+			}
+			event.Object = policy
+		}
+		return event, true
+	}), nil
+}
+
+func (c *spotPolicies) Create(ctx context.Context, policy *v1alpha1.SpotPolicy, opts metav1.CreateOptions) (*v1alpha1.SpotPolicy, error) {
+	obj, err := fromSpotPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	created, err := c.client.Create(ctx, obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return toSpotPolicy(created)
+}
+
+func (c *spotPolicies) Update(ctx context.Context, policy *v1alpha1.SpotPolicy, opts metav1.UpdateOptions) (*v1alpha1.SpotPolicy, error) {
+	obj, err := fromSpotPolicy(policy)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := c.client.Update(ctx, obj, opts)
+	if err != nil {
+		return nil, err
+	}
+	return toSpotPolicy(updated)
+}
+
+func (c *spotPolicies) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return c.client.Delete(ctx, name, opts)
+}
+
+func toSpotPolicy(obj *unstructured.Unstructured) (*v1alpha1.SpotPolicy, error) {
+	policy := &v1alpha1.SpotPolicy{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.UnstructuredContent(), policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func fromSpotPolicy(policy *v1alpha1.SpotPolicy) (*unstructured.Unstructured, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(policy)
+	if err != nil {
+		return nil, err
+	}
+	obj := &unstructured.Unstructured{Object: content}
+	obj.SetAPIVersion(v1alpha1.SchemeGroupVersion.String())
+	obj.SetKind("SpotPolicy")
+	return obj, nil
+}
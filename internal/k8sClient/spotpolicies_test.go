@@ -0,0 +1,106 @@
+package k8sClient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newSpotPolicyClient returns a client backed by a fake dynamic client. The fake stores
+// the policies as unstructured objects, like the api server serves them.
+func newSpotPolicyClient(t *testing.T, policies ...*v1alpha1.SpotPolicy) SpotPolicyInterface {
+	objects := []runtime.Object{}
+	for _, policy := range policies {
+		obj, err := fromSpotPolicy(policy)
+		assert.NoError(t, err)
+		objects = append(objects, obj)
+	}
+	return NewSpotPolicyClient(dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{v1alpha1.SpotPolicyResource: "SpotPolicyList"}, objects...))
+}
+
+func TestSpotPolicyClient_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	client := newSpotPolicyClient(t)
+	policy := &v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: "batch"}, Spec: v1alpha1.SpotPolicySpec{Rules: []v1alpha1.SpotPolicyRule{{
+		Name:        "jobs",
+		OwnerKinds:  []string{"Job"},
+		Action:      v1alpha1.ActionRequire,
+		Tolerations: []corev1.Toleration{{Key: "workload-class", Operator: corev1.TolerationOpEqual, Value: "batch", Effect: corev1.TaintEffectNoSchedule}},
+	}}}}
+
+	_, err := client.Create(context.TODO(), policy, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	stored, err := client.Get(context.TODO(), "batch", metav1.GetOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, policy.Spec, stored.Spec)
+
+	list, err := client.List(context.TODO(), metav1.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, list.Items, 1)
+
+	assert.NoError(t, client.Delete(context.TODO(), "batch", metav1.DeleteOptions{}))
+	_, err = client.Get(context.TODO(), "batch", metav1.GetOptions{})
+	assert.Error(t, err)
+}
+
+func TestCache_ServesSpotPoliciesInOrder(t *testing.T) {
+	t.Parallel()
+
+	policies := newSpotPolicyClient(t,
+		&v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: "b"}, Spec: v1alpha1.SpotPolicySpec{Priority: 10}},
+		&v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: "c"}},
+	)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	cache := NewCache(&mockK8sClient{clientset: servingSpotPolicies(fake.NewSimpleClientset()), spotPolicies: policies}, 0)
+	assert.NoError(t, cache.Start(stopCh))
+
+	names := func() []string {
+		result := []string{}
+		for _, policy := range cache.SpotPolicies() {
+			result = append(result, policy.Name)
+		}
+		return result
+	}
+	assert.Equal(t, []string{"c", "b"}, names())
+
+	_, err := policies.Create(context.TODO(), &v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: "a"}}, metav1.CreateOptions{})
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return len(names()) == 3 && names()[0] == "a"
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestCache_StartsWithoutSpotPolicyCRD(t *testing.T) {
+	t.Parallel()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+
+	cache := NewCache(&mockK8sClient{clientset: fake.NewSimpleClientset(), spotPolicies: newSpotPolicyClient(t)}, 0)
+	assert.NoError(t, cache.Start(stopCh))
+	assert.Nil(t, cache.SpotPolicies())
+}
+
+// servingSpotPolicies makes the discovery of the clientset report the SpotPolicy CRD.
+func servingSpotPolicies(clientset *fake.Clientset) *fake.Clientset {
+	clientset.Resources = []*metav1.APIResourceList{{
+		GroupVersion: v1alpha1.SchemeGroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: v1alpha1.SpotPolicyResource.Resource, Kind: "SpotPolicy"}},
+	}}
+	return clientset
+}
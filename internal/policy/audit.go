@@ -0,0 +1,30 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AuditAnnotation switches the audit mode on or off for a namespace. In audit mode pods are
+// not mutated, the mutation is only reported.
+const AuditAnnotation = "spot-tolerator.stein.solutions/audit"
+
+// AuditFromObject reads the audit annotation of the object and falls back to a label with
+// the same key. The second value reports whether the object declares the audit mode.
+func AuditFromObject(obj metav1.Object) (bool, bool, error) {
+	value, exists := obj.GetAnnotations()[AuditAnnotation]
+	if !exists {
+		value, exists = obj.GetLabels()[AuditAnnotation]
+	}
+	if !exists {
+		return false, false, nil
+	}
+
+	audit, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, false, fmt.Errorf("invalid audit mode %q", value)
+	}
+	return audit, true, nil
+}
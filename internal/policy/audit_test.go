@@ -0,0 +1,32 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAuditFromObject(t *testing.T) {
+	t.Parallel()
+
+	audit, found, err := AuditFromObject(&metav1.ObjectMeta{Labels: map[string]string{AuditAnnotation: "true"}})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.True(t, audit)
+
+	audit, found, err = AuditFromObject(&metav1.ObjectMeta{
+		Labels:      map[string]string{AuditAnnotation: "true"},
+		Annotations: map[string]string{AuditAnnotation: "false"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.False(t, audit)
+
+	_, found, err = AuditFromObject(&metav1.ObjectMeta{})
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, _, err = AuditFromObject(&metav1.ObjectMeta{Annotations: map[string]string{AuditAnnotation: "sometimes"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,193 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// celCostLimit bounds the runtime cost of a single match expression.
+const celCostLimit = 1000000
+
+// QuantityType is the CEL type of resource quantities. Quantities are created with
+// quantity("4Gi") and can be compared with each other.
+var QuantityType = cel.ObjectType("kubernetes.Quantity", traits.ComparerType)
+
+type quantity struct {
+	resource.Quantity
+}
+
+func (q quantity) ConvertToNative(typeDesc reflect.Type) (any, error) {
+	if reflect.TypeOf(q.Quantity).AssignableTo(typeDesc) {
+		return q.Quantity, nil
+	}
+	return nil, fmt.Errorf("type conversion error from quantity to %v", typeDesc)
+}
+
+func (q quantity) ConvertToType(typeVal ref.Type) ref.Val {
+	switch typeVal {
+	case QuantityType:
+		return q
+	case types.TypeType:
+		return QuantityType
+	case types.StringType:
+		return types.String(q.String())
+	}
+	return types.NewErr("type conversion error from %s to %s", QuantityType, typeVal)
+}
+
+func (q quantity) Equal(other ref.Val) ref.Val {
+	o, ok := other.(quantity)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(other)
+	}
+	return types.Bool(q.Cmp(o.Quantity) == 0)
+}
+
+func (q quantity) Compare(other ref.Val) ref.Val {
+	o, ok := other.(quantity)
+	if !ok {
+		return types.MaybeNoSuchOverloadErr(other)
+	}
+	return types.Int(q.Cmp(o.Quantity))
+}
+
+func (q quantity) Type() ref.Type {
+	return QuantityType
+}
+
+func (q quantity) Value() any {
+	return q.Quantity
+}
+
+// newCelEnv declares the variables available to match expressions: the pod as object, its
+// namespace as namespaceObject (null if unknown) and request.userInfo of the admission request.
+func newCelEnv() (*cel.Env, error) {
+	comparison := func(operator, name string) cel.EnvOption {
+		return cel.Function(operator, cel.Overload(name, []*cel.Type{QuantityType, QuantityType}, cel.BoolType))
+	}
+
+	return cel.NewEnv(
+		cel.Variable("object", cel.DynType),
+		cel.Variable("namespaceObject", cel.DynType),
+		cel.Variable("request", cel.DynType),
+		cel.Function("quantity", cel.Overload("string_to_quantity", []*cel.Type{cel.StringType}, QuantityType,
+			cel.UnaryBinding(func(value ref.Val) ref.Val {
+				q, err := resource.ParseQuantity(string(value.(types.String)))
+				if err != nil {
+					return types.NewErr("invalid quantity %q. %v", value, err)
+				}
+				return quantity{q}
+			}))),
+		comparison(operators.Less, "less_quantity"),
+		comparison(operators.LessEquals, "less_equals_quantity"),
+		comparison(operators.Greater, "greater_quantity"),
+		comparison(operators.GreaterEquals, "greater_equals_quantity"),
+	)
+}
+
+func compileExpression(env *cel.Env, expression string) (cel.Program, error) {
+	ast, issues := env.Compile(expression)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if ast.OutputType() != cel.BoolType && ast.OutputType() != cel.DynType {
+		return nil, fmt.Errorf("expression %q returns %s instead of bool", expression, ast.OutputType())
+	}
+	return env.Program(ast, cel.CostLimit(celCostLimit))
+}
+
+// celActivation converts the input into the variables of match expressions. Resource
+// quantities of the pod are converted into quantities, so they can be compared.
+func celActivation(input RuleInput) (map[string]any, error) {
+	object, err := toCelValue(input.Pod)
+	if err != nil {
+		return nil, err
+	}
+	if spec, ok := object["spec"].(map[string]any); ok {
+		for _, field := range []string{"containers", "initContainers"} {
+			containers, _ := spec[field].([]any)
+			for _, container := range containers {
+				if resources, ok := container.(map[string]any)["resources"].(map[string]any); ok {
+					convertQuantities(resources, "requests")
+					convertQuantities(resources, "limits")
+				}
+			}
+		}
+		convertQuantities(spec, "overhead")
+	}
+
+	var namespaceObject map[string]any
+	if input.Namespace != nil {
+		if namespaceObject, err = toCelValue(input.Namespace); err != nil {
+			return nil, err
+		}
+	}
+
+	userInfo, err := toCelValue(&input.UserInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]any{
+		"object":          object,
+		"namespaceObject": namespaceObject,
+		"request":         map[string]any{"userInfo": userInfo},
+	}, nil
+}
+
+func toCelValue[T *corev1.Pod | *corev1.Namespace | *authenticationv1.UserInfo](obj T) (map[string]any, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	value := map[string]any{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, err
+	}
+	convertIntegers(value)
+	return value, nil
+}
+
+// convertIntegers turns the numbers decoded from json back into integers where possible,
+// so that expressions like object.spec.priority > 1000 work as expected.
+func convertIntegers(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, item := range v {
+			v[key] = convertIntegers(item)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = convertIntegers(item)
+		}
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+	}
+	return value
+}
+
+func convertQuantities(parent map[string]any, field string) {
+	list, ok := parent[field].(map[string]any)
+	if !ok {
+		return
+	}
+	for name, value := range list {
+		if s, ok := value.(string); ok {
+			if q, err := resource.ParseQuantity(s); err == nil {
+				list[name] = quantity{q}
+			}
+		}
+	}
+}
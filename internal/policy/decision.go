@@ -0,0 +1,80 @@
+package policy
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DecisionAnnotation records on mutated pods how the mode was decided.
+	DecisionAnnotation = "spot-tolerator.stein.solutions/decision"
+	// MutatedLabel marks pods mutated by the webhook.
+	MutatedLabel = "spot-tolerator.stein.solutions/mutated"
+)
+
+// Source names where the mode of a decision comes from.
+type Source string
+
+const (
+	SourceDefault   Source = "default"
+	SourceKind      Source = "kind"
+	SourceNamespace Source = "namespace"
+	SourceRule      Source = "rule"
+	SourceOwner     Source = "owner"
+	SourcePod       Source = "pod"
+)
+
+type Decision struct {
+	Mode   Mode
+	Source Source
+	Reason string
+	// Rule names the SpotPolicy rule that matched the pod, if any.
+	Rule string
+	// Tolerations and NodeAffinity of the matching rule, injected in addition to the
+	// configured ones.
+	Tolerations  []corev1.Toleration
+	NodeAffinity *corev1.NodeAffinity
+	// Warnings are returned to the client with the admission response.
+	Warnings []string
+}
+
+// Override returns the decision with another mode, keeping the matching rule and what it injects.
+func (d Decision) Override(mode Mode, source Source, reason string) Decision {
+	d.Mode = mode
+	d.Source = source
+	d.Reason = reason
+	return d
+}
+
+// Annotation returns the value of the decision annotation: the mode, the matching rule and
+// the reason as json.
+func (d Decision) Annotation() string {
+	value, err := json.Marshal(struct {
+		Mode   Mode   `json:"mode"`
+		Rule   string `json:"rule,omitempty"`
+		Reason string `json:"reason"`
+	}{d.Mode, d.Rule, d.Reason})
+	if err != nil {
+		// marshalling strings does not fail
+		return ""
+	}
+	return string(value)
+}
+
+// RecordedMode returns the mode recorded in the decision annotation of the object.
+func RecordedMode(obj metav1.Object) (Mode, bool) {
+	value, exists := obj.GetAnnotations()[DecisionAnnotation]
+	if !exists {
+		return "", false
+	}
+
+	recorded := struct {
+		Mode Mode `json:"mode"`
+	}{}
+	if err := json.Unmarshal([]byte(value), &recorded); err != nil || recorded.Mode == "" {
+		return "", false
+	}
+	return recorded.Mode, true
+}
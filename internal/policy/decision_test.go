@@ -0,0 +1,23 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRecordedMode(t *testing.T) {
+	t.Parallel()
+
+	decision := Decision{Mode: ModeRequire, Reason: "rule batch/ci", Rule: "batch/ci"}
+	mode, found := RecordedMode(&metav1.ObjectMeta{Annotations: map[string]string{DecisionAnnotation: decision.Annotation()}})
+	assert.True(t, found)
+	assert.Equal(t, ModeRequire, mode)
+
+	_, found = RecordedMode(&metav1.ObjectMeta{})
+	assert.False(t, found)
+
+	_, found = RecordedMode(&metav1.ObjectMeta{Annotations: map[string]string{DecisionAnnotation: "require"}})
+	assert.False(t, found)
+}
@@ -0,0 +1,42 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// FallbackAnnotation marks workloads whose pods could not be scheduled on spot nodes. Its value
+// is the time the fallback started. New pods of marked workloads do not require spot nodes.
+const FallbackAnnotation = "spot-tolerator.stein.solutions/fallback"
+
+// FallbackSpotNodesAnnotation records the number of ready spot nodes when the fallback started.
+// The fallback ends only once more spot nodes are ready.
+const FallbackSpotNodesAnnotation = "spot-tolerator.stein.solutions/fallback-spot-nodes"
+
+// FallbackFromObject reads the time of the fallback from the fallback annotation of the object.
+// The boolean reports whether the object is marked.
+func FallbackFromObject(obj metav1.Object) (time.Time, bool, error) {
+	value, exists := obj.GetAnnotations()[FallbackAnnotation]
+	if !exists {
+		return time.Time{}, false, nil
+	}
+
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, true, fmt.Errorf("invalid fallback time %q", value)
+	}
+	return since, true, nil
+}
+
+// FallbackSpotNodesFromObject reads the number of ready spot nodes recorded when the fallback of
+// the object started. The boolean reports whether a valid number is recorded.
+func FallbackSpotNodesFromObject(obj metav1.Object) (int, bool) {
+	nodes, err := strconv.Atoi(obj.GetAnnotations()[FallbackSpotNodesAnnotation])
+	if err != nil || nodes < 0 {
+		return 0, false
+	}
+	return nodes, true
+}
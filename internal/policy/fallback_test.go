@@ -0,0 +1,40 @@
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFallbackFromObject(t *testing.T) {
+	t.Parallel()
+
+	since, found, err := FallbackFromObject(&metav1.ObjectMeta{Annotations: map[string]string{FallbackAnnotation: "2024-10-02T10:00:00Z"}})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, time.Date(2024, 10, 2, 10, 0, 0, 0, time.UTC), since)
+
+	_, found, err = FallbackFromObject(&metav1.ObjectMeta{})
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, found, err = FallbackFromObject(&metav1.ObjectMeta{Annotations: map[string]string{FallbackAnnotation: "yesterday"}})
+	assert.Error(t, err)
+	assert.True(t, found)
+}
+
+func TestFallbackSpotNodesFromObject(t *testing.T) {
+	t.Parallel()
+
+	nodes, found := FallbackSpotNodesFromObject(&metav1.ObjectMeta{Annotations: map[string]string{FallbackSpotNodesAnnotation: "3"}})
+	assert.True(t, found)
+	assert.Equal(t, 3, nodes)
+
+	_, found = FallbackSpotNodesFromObject(&metav1.ObjectMeta{})
+	assert.False(t, found)
+
+	_, found = FallbackSpotNodesFromObject(&metav1.ObjectMeta{Annotations: map[string]string{FallbackSpotNodesAnnotation: "many"}})
+	assert.False(t, found)
+}
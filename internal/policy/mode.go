@@ -0,0 +1,92 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	ModeAnnotation      = "spot-tolerator.stein.solutions/mode"
+	SpotRatioAnnotation = "spot-tolerator.stein.solutions/spot-ratio"
+)
+
+type Mode string
+
+const (
+	// ModeSkip leaves the pod untouched.
+	ModeSkip Mode = "skip"
+	// ModeTolerate only allows the pod to run on spot nodes.
+	ModeTolerate Mode = "tolerate"
+	// ModePrefer allows the pod to run on spot nodes and asks the scheduler to prefer them.
+	ModePrefer Mode = "prefer"
+	// ModeRequire forces the pod onto spot nodes.
+	ModeRequire Mode = "require"
+)
+
+var modeStrength = map[Mode]int{
+	ModeSkip:     0,
+	ModeTolerate: 1,
+	ModePrefer:   2,
+	ModeRequire:  3,
+}
+
+// Cap returns the weaker of the mode and the limit.
+func (m Mode) Cap(limit Mode) Mode {
+	if modeStrength[limit] < modeStrength[m] {
+		return limit
+	}
+	return m
+}
+
+// ParseMode converts an annotation or label value into a Mode. Besides the mode names
+// themselves the aliases used by the annotations and namespace labels are accepted.
+func ParseMode(value string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "skip", "off":
+		return ModeSkip, nil
+	case "tolerate", "inject":
+		return ModeTolerate, nil
+	case "prefer", "prefer-spot":
+		return ModePrefer, nil
+	case "require", "require-spot":
+		return ModeRequire, nil
+	default:
+		return "", fmt.Errorf("unknown mode %q", value)
+	}
+}
+
+// ModeFromObject reads the mode from the mode annotation of the object and falls back
+// to a label with the same key. The boolean reports whether the object declares a mode.
+func ModeFromObject(obj metav1.Object) (Mode, bool, error) {
+	value, exists := obj.GetAnnotations()[ModeAnnotation]
+	if !exists {
+		value, exists = obj.GetLabels()[ModeAnnotation]
+	}
+	if !exists {
+		return "", false, nil
+	}
+
+	mode, err := ParseMode(value)
+	if err != nil {
+		return "", false, err
+	}
+	return mode, true, nil
+}
+
+// ParseSpotRatio reads the percentage of replicas that should target spot nodes from the
+// spot ratio annotation of the object.
+func ParseSpotRatio(obj metav1.Object) (int, bool, error) {
+	value, exists := obj.GetAnnotations()[SpotRatioAnnotation]
+	if !exists {
+		return 0, false, nil
+	}
+
+	ratio, err := strconv.Atoi(strings.TrimSpace(strings.TrimSuffix(value, "%")))
+	if err != nil || ratio < 0 || ratio > 100 {
+		return 0, false, fmt.Errorf("spot ratio %q has to be a percentage between 0 and 100", value)
+	}
+	return ratio, true, nil
+}
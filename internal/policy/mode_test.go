@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseMode(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]Mode{
+		"skip":         ModeSkip,
+		"off":          ModeSkip,
+		"inject":       ModeTolerate,
+		"tolerate":     ModeTolerate,
+		"prefer-spot":  ModePrefer,
+		" Require ":    ModeRequire,
+		"require-spot": ModeRequire,
+	}
+
+	for value, expected := range cases {
+		mode, err := ParseMode(value)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, mode, value)
+	}
+}
+
+func TestParseMode_Unknown(t *testing.T) {
+	t.Parallel()
+
+	_, err := ParseMode("sometimes")
+	assert.Error(t, err)
+}
+
+func TestParseSpotRatio(t *testing.T) {
+	t.Parallel()
+
+	ratio, found, err := ParseSpotRatio(&metav1.ObjectMeta{Annotations: map[string]string{SpotRatioAnnotation: "70"}})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 70, ratio)
+
+	ratio, found, err = ParseSpotRatio(&metav1.ObjectMeta{Annotations: map[string]string{SpotRatioAnnotation: "25%"}})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 25, ratio)
+
+	_, found, err = ParseSpotRatio(&metav1.ObjectMeta{})
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, _, err = ParseSpotRatio(&metav1.ObjectMeta{Annotations: map[string]string{SpotRatioAnnotation: "101"}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,48 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// PlacementAnnotation restricts the nodes the pods of a namespace may run on. Pods violating
+// the placement are rejected by the validating webhook.
+const PlacementAnnotation = "spot-tolerator.stein.solutions/placement"
+
+type Placement string
+
+const (
+	// PlacementSpotOnly only allows pods that run on spot nodes.
+	PlacementSpotOnly Placement = "spot-only"
+	// PlacementNeverSpot only allows pods that do not tolerate spot nodes.
+	PlacementNeverSpot Placement = "never-spot"
+)
+
+func ParsePlacement(value string) (Placement, error) {
+	switch placement := Placement(strings.ToLower(strings.TrimSpace(value))); placement {
+	case PlacementSpotOnly, PlacementNeverSpot:
+		return placement, nil
+	default:
+		return "", fmt.Errorf("unknown placement %q", value)
+	}
+}
+
+// PlacementFromObject reads the placement annotation of the object and falls back to a label
+// with the same key. The boolean reports whether the object declares a placement.
+func PlacementFromObject(obj metav1.Object) (Placement, bool, error) {
+	value, exists := obj.GetAnnotations()[PlacementAnnotation]
+	if !exists {
+		value, exists = obj.GetLabels()[PlacementAnnotation]
+	}
+	if !exists {
+		return "", false, nil
+	}
+
+	placement, err := ParsePlacement(value)
+	if err != nil {
+		return "", false, err
+	}
+	return placement, true, nil
+}
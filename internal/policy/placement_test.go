@@ -0,0 +1,32 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPlacementFromObject(t *testing.T) {
+	t.Parallel()
+
+	placement, found, err := PlacementFromObject(&metav1.ObjectMeta{Labels: map[string]string{PlacementAnnotation: "Spot-Only"}})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, PlacementSpotOnly, placement)
+
+	placement, found, err = PlacementFromObject(&metav1.ObjectMeta{
+		Labels:      map[string]string{PlacementAnnotation: "spot-only"},
+		Annotations: map[string]string{PlacementAnnotation: "never-spot"},
+	})
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, PlacementNeverSpot, placement)
+
+	_, found, err = PlacementFromObject(&metav1.ObjectMeta{})
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	_, _, err = PlacementFromObject(&metav1.ObjectMeta{Annotations: map[string]string{PlacementAnnotation: "sometimes-spot"}})
+	assert.Error(t, err)
+}
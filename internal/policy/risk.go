@@ -0,0 +1,67 @@
+package policy
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+const SafeToEvictAnnotation = "cluster-autoscaler.kubernetes.io/safe-to-evict"
+
+// Risk describes a property of a pod that makes an eviction from a spot node costly.
+type Risk string
+
+const (
+	RiskPersistentVolumeClaim Risk = "persistentVolumeClaim"
+	RiskHostPath              Risk = "hostPath"
+	RiskHostNetwork           Risk = "hostNetwork"
+	RiskHostPort              Risk = "hostPort"
+	RiskNotSafeToEvict        Risk = "notSafeToEvict"
+)
+
+func (r Risk) IsValid() bool {
+	switch r {
+	case RiskPersistentVolumeClaim, RiskHostPath, RiskHostNetwork, RiskHostPort, RiskNotSafeToEvict:
+		return true
+	}
+	return false
+}
+
+// ClassifyRisks returns the risks of the pod in a stable order.
+func ClassifyRisks(pod *corev1.Pod) []Risk {
+	risks := []Risk{}
+
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil || volume.Ephemeral != nil {
+			risks = append(risks, RiskPersistentVolumeClaim)
+			break
+		}
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.HostPath != nil {
+			risks = append(risks, RiskHostPath)
+			break
+		}
+	}
+	if pod.Spec.HostNetwork {
+		risks = append(risks, RiskHostNetwork)
+	}
+	if usesHostPort(pod) {
+		risks = append(risks, RiskHostPort)
+	}
+	if pod.Annotations[SafeToEvictAnnotation] == "false" {
+		risks = append(risks, RiskNotSafeToEvict)
+	}
+
+	return risks
+}
+
+func usesHostPort(pod *corev1.Pod) bool {
+	containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			if port.HostPort > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
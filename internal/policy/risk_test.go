@@ -0,0 +1,56 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestClassifyRisks_None(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		Volumes:    []corev1.Volume{{Name: "tmp", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}},
+		Containers: []corev1.Container{{Name: "app", Ports: []corev1.ContainerPort{{ContainerPort: 8080}}}},
+	}}
+
+	assert.Empty(t, ClassifyRisks(pod))
+}
+
+func TestClassifyRisks_All(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{SafeToEvictAnnotation: "false"}},
+		Spec: corev1.PodSpec{
+			HostNetwork: true,
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"}}},
+				{Name: "logs", VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/log"}}},
+			},
+			InitContainers: []corev1.Container{{Name: "init", Ports: []corev1.ContainerPort{{ContainerPort: 53, HostPort: 53}}}},
+		},
+	}
+
+	assert.Equal(t, []Risk{RiskPersistentVolumeClaim, RiskHostPath, RiskHostNetwork, RiskHostPort, RiskNotSafeToEvict}, ClassifyRisks(pod))
+}
+
+func TestClassifyRisks_EphemeralVolume(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{Spec: corev1.PodSpec{
+		Volumes: []corev1.Volume{{Name: "scratch", VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}}}},
+	}}
+
+	assert.Equal(t, []Risk{RiskPersistentVolumeClaim}, ClassifyRisks(pod))
+}
+
+func TestModeCap(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, ModeTolerate, ModeRequire.Cap(ModeTolerate))
+	assert.Equal(t, ModeSkip, ModePrefer.Cap(ModeSkip))
+	assert.Equal(t, ModeTolerate, ModeTolerate.Cap(ModeRequire))
+}
@@ -0,0 +1,256 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// RuleInput is what the rules of a SpotPolicy are matched against.
+type RuleInput struct {
+	// Namespace of the pod, nil if it is unknown. Namespace selectors other than the empty
+	// selector do not match pods in unknown namespaces.
+	Namespace *corev1.Namespace
+	Pod       *corev1.Pod
+	// OwnerKind is the kind of the top-level owner of the pod, Pod for pods without owner.
+	OwnerKind string
+	// UserInfo of the admission request.
+	UserInfo authenticationv1.UserInfo
+}
+
+// RuleMatch is the rule that matched a pod.
+type RuleMatch struct {
+	// Name identifies the rule as <policy>/<rule>. Rules without name are identified by
+	// their index.
+	Name string
+	Mode Mode
+	Rule *v1alpha1.SpotPolicyRule
+}
+
+// Evaluator matches pods against the rules of SpotPolicies. The match expressions of the
+// rules are compiled once per policy and cached until the expressions of the policy change.
+type Evaluator struct {
+	env      *cel.Env
+	mu       sync.RWMutex
+	compiled map[string][]compiledRule
+}
+
+type compiledRule struct {
+	match    []string
+	programs []cel.Program
+	err      error
+}
+
+func NewEvaluator() *Evaluator {
+	env, err := newCelEnv()
+	if err != nil {
+		// the declarations of the environment are static, so this is a programming error
+		panic(fmt.Sprintf("could not create cel environment: %v", err))
+	}
+	return &Evaluator{
+		env:      env,
+		compiled: map[string][]compiledRule{},
+	}
+}
+
+// Load compiles the match expressions of the policy and validates its rules. The returned
+// error describes all invalid rules, they are skipped during evaluation.
+func (e *Evaluator) Load(spotPolicy *v1alpha1.SpotPolicy) error {
+	rules := e.compile(spotPolicy)
+
+	errs := []error{}
+	for i, rule := range rules {
+		err := rule.err
+		if err == nil {
+			err = validateSelectors(&spotPolicy.Spec.Rules[i])
+		}
+		if err == nil {
+			_, err = ValidateRule(&spotPolicy.Spec.Rules[i])
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("rule %s: %w", RuleName(spotPolicy, i), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Forget drops the compiled expressions of a deleted policy.
+func (e *Evaluator) Forget(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.compiled, name)
+}
+
+// MatchRule returns the first rule of the policies matching the input or nil if no rule
+// matches. The policies are expected in order of evaluation. Invalid rules are skipped.
+func (e *Evaluator) MatchRule(policies []*v1alpha1.SpotPolicy, input RuleInput) *RuleMatch {
+	var activation map[string]any
+
+	for _, spotPolicy := range policies {
+		var rules []compiledRule
+		for i := range spotPolicy.Spec.Rules {
+			rule := &spotPolicy.Spec.Rules[i]
+			name := RuleName(spotPolicy, i)
+
+			matches, err := matchesRule(rule, input)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Ignoring invalid rule %s. %v", name, err))
+				continue
+			}
+			if !matches {
+				continue
+			}
+
+			mode, err := ValidateRule(rule)
+			if err != nil {
+				slog.Warn(fmt.Sprintf("Ignoring invalid rule %s. %v", name, err))
+				continue
+			}
+
+			if len(rule.Match) > 0 {
+				if rules == nil {
+					rules = e.compile(spotPolicy)
+				}
+				if rules[i].err != nil {
+					slog.Warn(fmt.Sprintf("Ignoring invalid rule %s. %v", name, rules[i].err))
+					continue
+				}
+				if activation == nil {
+					if activation, err = celActivation(input); err != nil {
+						slog.Error(fmt.Sprintf("Could not evaluate match expressions of rule %s. %v", name, err))
+						return nil
+					}
+				}
+				if !evaluate(name, rules[i].programs, activation) {
+					continue
+				}
+			}
+
+			return &RuleMatch{Name: name, Mode: mode, Rule: rule}
+		}
+	}
+	return nil
+}
+
+// compile returns the compiled rules of the policy, from cache if its expressions did not change.
+func (e *Evaluator) compile(spotPolicy *v1alpha1.SpotPolicy) []compiledRule {
+	e.mu.RLock()
+	rules, exists := e.compiled[spotPolicy.Name]
+	e.mu.RUnlock()
+	if exists && len(rules) == len(spotPolicy.Spec.Rules) && slices.EqualFunc(rules, spotPolicy.Spec.Rules,
+		func(compiled compiledRule, rule v1alpha1.SpotPolicyRule) bool {
+			return slices.Equal(compiled.match, rule.Match)
+		}) {
+		return rules
+	}
+
+	rules = make([]compiledRule, 0, len(spotPolicy.Spec.Rules))
+	for _, rule := range spotPolicy.Spec.Rules {
+		compiled := compiledRule{match: slices.Clone(rule.Match)}
+		for _, expression := range rule.Match {
+			program, err := compileExpression(e.env, expression)
+			if err != nil {
+				compiled.err = fmt.Errorf("invalid match expression %q. %w", expression, err)
+				compiled.programs = nil
+				break
+			}
+			compiled.programs = append(compiled.programs, program)
+		}
+		rules = append(rules, compiled)
+	}
+
+	e.mu.Lock()
+	e.compiled[spotPolicy.Name] = rules
+	e.mu.Unlock()
+	return rules
+}
+
+// evaluate reports whether all programs return true. Expressions failing at runtime, e.g.
+// because a field does not exist, do not match.
+func evaluate(name string, programs []cel.Program, activation map[string]any) bool {
+	for _, program := range programs {
+		result, _, err := program.Eval(activation)
+		if err != nil {
+			slog.Debug(fmt.Sprintf("Match expression of rule %s failed. %v", name, err))
+			return false
+		}
+		if result != types.True {
+			return false
+		}
+	}
+	return true
+}
+
+// RuleName returns the name of the i-th rule of the policy.
+func RuleName(spotPolicy *v1alpha1.SpotPolicy, i int) string {
+	if name := spotPolicy.Spec.Rules[i].Name; name != "" {
+		return fmt.Sprintf("%s/%s", spotPolicy.Name, name)
+	}
+	return fmt.Sprintf("%s/%d", spotPolicy.Name, i)
+}
+
+// ValidateRule checks the parts of the rule that are not checked while matching and returns
+// the mode of its action.
+func ValidateRule(rule *v1alpha1.SpotPolicyRule) (Mode, error) {
+	mode, err := ParseMode(string(rule.Action))
+	if err != nil {
+		return "", fmt.Errorf("invalid action. %w", err)
+	}
+
+	if rule.NodeAffinity != nil && rule.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		required := rule.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+		if len(required.NodeSelectorTerms) > 1 {
+			return "", fmt.Errorf("required node affinity has %d node selector terms, at most one is supported", len(required.NodeSelectorTerms))
+		}
+		if len(required.NodeSelectorTerms) == 1 && len(required.NodeSelectorTerms[0].MatchFields) > 0 {
+			return "", fmt.Errorf("required node affinity may only contain match expressions")
+		}
+	}
+	return mode, nil
+}
+
+func validateSelectors(rule *v1alpha1.SpotPolicyRule) error {
+	for _, selector := range []*metav1.LabelSelector{rule.NamespaceSelector, rule.PodSelector} {
+		if _, err := matchesSelector(selector, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matchesRule(rule *v1alpha1.SpotPolicyRule, input RuleInput) (bool, error) {
+	if len(rule.OwnerKinds) > 0 && !slices.Contains(rule.OwnerKinds, input.OwnerKind) {
+		return false, nil
+	}
+
+	var namespaceLabels map[string]string
+	if input.Namespace != nil {
+		namespaceLabels = input.Namespace.Labels
+	}
+	if matches, err := matchesSelector(rule.NamespaceSelector, namespaceLabels); err != nil || !matches {
+		return false, err
+	}
+
+	return matchesSelector(rule.PodSelector, input.Pod.Labels)
+}
+
+func matchesSelector(selector *metav1.LabelSelector, objectLabels map[string]string) (bool, error) {
+	if selector == nil {
+		return true, nil
+	}
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	return s.Matches(labels.Set(objectLabels)), nil
+}
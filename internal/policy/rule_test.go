@@ -0,0 +1,164 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/apis/v1alpha1"
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func spotPolicy(name string, rules ...v1alpha1.SpotPolicyRule) *v1alpha1.SpotPolicy {
+	return &v1alpha1.SpotPolicy{ObjectMeta: metav1.ObjectMeta{Name: name}, Spec: v1alpha1.SpotPolicySpec{Rules: rules}}
+}
+
+func ruleInput(namespaceLabels, podLabels map[string]string, ownerKind string) RuleInput {
+	return RuleInput{
+		Namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: namespaceLabels}},
+		Pod:       &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod", Labels: podLabels}},
+		OwnerKind: ownerKind,
+	}
+}
+
+func TestMatchRule_FirstMatchWins(t *testing.T) {
+	t.Parallel()
+
+	policies := []*v1alpha1.SpotPolicy{
+		spotPolicy("batch",
+			v1alpha1.SpotPolicyRule{Name: "jobs", OwnerKinds: []string{"Job", "CronJob"}, Action: v1alpha1.ActionRequire},
+			v1alpha1.SpotPolicyRule{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "batch"}}, Action: v1alpha1.ActionPrefer},
+		),
+		spotPolicy("fallback", v1alpha1.SpotPolicyRule{Name: "all", Action: v1alpha1.ActionTolerate}),
+	}
+
+	evaluator := NewEvaluator()
+	match := evaluator.MatchRule(policies, ruleInput(nil, nil, "CronJob"))
+	assert.Equal(t, "batch/jobs", match.Name)
+	assert.Equal(t, ModeRequire, match.Mode)
+
+	match = evaluator.MatchRule(policies, ruleInput(nil, map[string]string{"tier": "batch"}, "Deployment"))
+	assert.Equal(t, "batch/1", match.Name)
+	assert.Equal(t, ModePrefer, match.Mode)
+
+	match = evaluator.MatchRule(policies, ruleInput(nil, nil, "Deployment"))
+	assert.Equal(t, "fallback/all", match.Name)
+}
+
+func TestMatchRule_NamespaceSelector(t *testing.T) {
+	t.Parallel()
+
+	policies := []*v1alpha1.SpotPolicy{spotPolicy("ci", v1alpha1.SpotPolicyRule{
+		NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "team", Operator: metav1.LabelSelectorOpIn, Values: []string{"ci", "batch"}},
+		}},
+		Action: v1alpha1.ActionRequire,
+	})}
+
+	assert.NotNil(t, NewEvaluator().MatchRule(policies, ruleInput(map[string]string{"team": "ci"}, nil, "Pod")))
+	assert.Nil(t, NewEvaluator().MatchRule(policies, ruleInput(map[string]string{"team": "web"}, nil, "Pod")))
+	assert.Nil(t, NewEvaluator().MatchRule(policies, RuleInput{Pod: &corev1.Pod{}, OwnerKind: "Pod"}))
+}
+
+func TestMatchRule_SkipsInvalidRules(t *testing.T) {
+	t.Parallel()
+
+	policies := []*v1alpha1.SpotPolicy{spotPolicy("invalid",
+		v1alpha1.SpotPolicyRule{Name: "action", Action: "sometimes"},
+		v1alpha1.SpotPolicyRule{Name: "selector", PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: "tier", Operator: "Maybe"},
+		}}, Action: v1alpha1.ActionRequire},
+		v1alpha1.SpotPolicyRule{Name: "valid", Action: v1alpha1.ActionSkip},
+	)}
+
+	match := NewEvaluator().MatchRule(policies, ruleInput(nil, nil, "Pod"))
+	assert.Equal(t, "invalid/valid", match.Name)
+	assert.Equal(t, ModeSkip, match.Mode)
+}
+
+func TestValidateRule_RequiredAffinity(t *testing.T) {
+	t.Parallel()
+
+	term := corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "pool", Operator: corev1.NodeSelectorOpIn, Values: []string{"batch"}}}}
+	rule := &v1alpha1.SpotPolicyRule{Action: v1alpha1.ActionRequire, NodeAffinity: &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{term}},
+	}}
+
+	_, err := ValidateRule(rule)
+	assert.NoError(t, err)
+
+	rule.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms = []corev1.NodeSelectorTerm{term, term}
+	_, err = ValidateRule(rule)
+	assert.Error(t, err)
+}
+
+func TestMatchRule_Expressions(t *testing.T) {
+	t.Parallel()
+
+	policies := []*v1alpha1.SpotPolicy{spotPolicy("cel",
+		v1alpha1.SpotPolicyRule{Name: "small", Action: v1alpha1.ActionRequire, Match: []string{
+			`object.spec.containers.all(c, c.resources.requests.memory < quantity("4Gi"))`,
+			`namespaceObject.metadata.labels["team"] == "batch"`,
+		}},
+		v1alpha1.SpotPolicyRule{Name: "ci", Action: v1alpha1.ActionPrefer, Match: []string{
+			`request.userInfo.username.startsWith("system:serviceaccount:ci:")`,
+		}},
+	)}
+
+	input := ruleInput(map[string]string{"team": "batch"}, nil, "Pod")
+	input.Pod.Spec.Containers = []corev1.Container{{Name: "app", Resources: corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("2Gi")},
+	}}}
+	evaluator := NewEvaluator()
+
+	assert.Equal(t, "cel/small", evaluator.MatchRule(policies, input).Name)
+
+	input.Pod.Spec.Containers[0].Resources.Requests[corev1.ResourceMemory] = resource.MustParse("8Gi")
+	assert.Nil(t, evaluator.MatchRule(policies, input))
+
+	input.UserInfo = authenticationv1.UserInfo{Username: "system:serviceaccount:ci:runner"}
+	assert.Equal(t, "cel/ci", evaluator.MatchRule(policies, input).Name)
+}
+
+func TestMatchRule_ExpressionsFailingAtRuntimeDoNotMatch(t *testing.T) {
+	t.Parallel()
+
+	policies := []*v1alpha1.SpotPolicy{spotPolicy("cel",
+		v1alpha1.SpotPolicyRule{Action: v1alpha1.ActionRequire, Match: []string{`object.metadata.labels["missing"] == "x"`}},
+	)}
+
+	assert.Nil(t, NewEvaluator().MatchRule(policies, ruleInput(nil, nil, "Pod")))
+}
+
+func TestEvaluator_LoadReportsInvalidRules(t *testing.T) {
+	t.Parallel()
+
+	evaluator := NewEvaluator()
+	valid := spotPolicy("valid", v1alpha1.SpotPolicyRule{Action: v1alpha1.ActionSkip, Match: []string{`object.spec.priority > 1000`}})
+	assert.NoError(t, evaluator.Load(valid))
+
+	invalid := spotPolicy("invalid",
+		v1alpha1.SpotPolicyRule{Name: "syntax", Action: v1alpha1.ActionSkip, Match: []string{`object.spec.(`}},
+		v1alpha1.SpotPolicyRule{Name: "type", Action: v1alpha1.ActionSkip, Match: []string{`"spot"`}},
+		v1alpha1.SpotPolicyRule{Name: "action", Action: "sometimes"},
+	)
+	err := evaluator.Load(invalid)
+	assert.ErrorContains(t, err, "rule invalid/syntax")
+	assert.ErrorContains(t, err, "rule invalid/type")
+	assert.ErrorContains(t, err, "rule invalid/action")
+	assert.Nil(t, evaluator.MatchRule([]*v1alpha1.SpotPolicy{invalid}, ruleInput(nil, nil, "Pod")))
+}
+
+func TestEvaluator_RecompilesChangedExpressions(t *testing.T) {
+	t.Parallel()
+
+	evaluator := NewEvaluator()
+	policy := spotPolicy("cel", v1alpha1.SpotPolicyRule{Action: v1alpha1.ActionSkip, Match: []string{`false`}})
+	assert.NoError(t, evaluator.Load(policy))
+	assert.Nil(t, evaluator.MatchRule([]*v1alpha1.SpotPolicy{policy}, ruleInput(nil, nil, "Pod")))
+
+	policy = spotPolicy("cel", v1alpha1.SpotPolicyRule{Action: v1alpha1.ActionSkip, Match: []string{`true`}})
+	assert.NotNil(t, evaluator.MatchRule([]*v1alpha1.SpotPolicy{policy}, ruleInput(nil, nil, "Pod")))
+}
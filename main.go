@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/config"
 	"github.com/stein-solutions/aks-spot-instance-tolerator/internal/controller"
@@ -18,11 +19,21 @@ func main() {
 	config := config.NewConfig()
 	slog.SetLogLoggerLevel(config.LogLevel)
 
+	client := k8sClient.NewK8sClientDefault()
+	if client == nil {
+		fmt.Println("Failed to create kubernetes client")
+		os.Exit(1)
+	}
+
+	if config.NodeAgent {
+		runNodeAgent(config, client)
+	}
+
 	watcher := util.NewSecretWatcher(config.CertDirPath)
 	watcher.WatchSecret()
 
 	ch := make(chan bool)
-	webhookController := controller.NewWebhookController(k8sClient.NewK8sClientDefault(), config, watcher)
+	webhookController := controller.NewWebhookController(client, config, watcher)
 	go webhookController.StartWebhookController(ch)
 
 	success := <-ch
@@ -30,9 +41,38 @@ func main() {
 		fmt.Println("Failed to initialize webhook")
 		os.Exit(1)
 	}
+	stopCh := make(chan struct{})
+	cache := k8sClient.NewCache(client, time.Second*time.Duration(config.CacheResyncSeconds))
+	if err := cache.Start(stopCh); err != nil {
+		fmt.Println("Failed to start informer cache")
+		os.Exit(1)
+	}
+
+	if config.FallbackAfterSeconds > 0 {
+		controller.NewPendingPodController(client, cache, config).Start(stopCh)
+	}
+	if config.RebalanceSeconds > 0 {
+		controller.NewRebalanceController(client, cache, config).Start(stopCh)
+	}
+
 	slog.Info("Webhook Controller initialized successfully - Starting Server")
-	http.StartHttpServer(config, watcher)
+	http.StartHttpServer(config, watcher, cache)
+
+	health.StartHealthProbes(config)
+
+	select {}
+}
+
+// runNodeAgent handles the preemption of the spot node the agent runs on instead of serving
+// the webhook.
+func runNodeAgent(config *config.Config, client k8sClient.K8sClientInterface) {
+	if config.NodeName == "" {
+		fmt.Println("The node agent requires NODE_NAME to be set")
+		os.Exit(1)
+	}
 
+	slog.Info(fmt.Sprintf("Starting node agent on node %s", config.NodeName))
+	controller.NewPreemptionController(client, config).Start(make(chan struct{}))
 	health.StartHealthProbes(config)
 
 	select {}
@@ -0,0 +1,11 @@
+#!/bin/bash
+
+# Regenerates the deep-copy functions of the api types.
+# Requires deepcopy-gen: go install k8s.io/code-generator/cmd/deepcopy-gen@v0.30.3
+
+SCRIPT_ROOT=$(dirname "${BASH_SOURCE[0]}")/..
+
+cd "$SCRIPT_ROOT" && deepcopy-gen \
+  --go-header-file scripts/boilerplate.go.txt \
+  --output-file zz_generated.deepcopy.go \
+  ./internal/apis/...